@@ -1,58 +1,124 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
+	"time"
+	_ "time/tzdata" // job time zones must resolve without system zoneinfo
 
+	"github.com/abhilashreddysh/croncraft/internal/auth"
+	"github.com/abhilashreddysh/croncraft/internal/config"
 	"github.com/abhilashreddysh/croncraft/internal/db"
 	"github.com/abhilashreddysh/croncraft/internal/handlers"
 	"github.com/abhilashreddysh/croncraft/internal/jobs"
+	"github.com/abhilashreddysh/croncraft/internal/logstore"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+	"github.com/abhilashreddysh/croncraft/internal/utils"
 )
 
-const (
-	DBFile        = "croncraft.db"
-	
-	serverPort    = ":8080"
-)
+// httpShutdownTimeout bounds how long open requests may take to finish
+const httpShutdownTimeout = 10 * time.Second
 
 func main() {
-	if err := db.InitializeDatabase(DBFile); err != nil {
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := applyConfig(cfg); err != nil {
+		log.Fatalf("Failed to apply configuration: %v", err)
+	}
+
+	if len(args) > 0 {
+		if args[0] != "migrate" {
+			log.Fatalf("Unknown command %q", args[0])
+		}
+		os.Exit(migrateCommand(cfg, args[1:]))
+	}
+
+	if err := db.InitializeDatabase(cfg.DBPath()); err != nil {
 		log.Fatalf("Failed to initialize database: %v", err)
 	}
 
+	// Create the first account on a fresh database
+	if err := auth.EnsureAdmin(); err != nil {
+		log.Fatalf("Failed to set up accounts: %v", err)
+	}
+
 	jobs.InitializeCron()
-	defer jobs.C.Stop()
+
+	// Settle runs cut short by the previous shutdown before scheduling
+	jobs.RecoverInterruptedRuns()
+
+	// Start the runs missed while we were down
+	jobs.CatchUpMissedRuns()
 
 	// Load existing jobs
 	jobs.LoadJobs()
 
+	// Remove runs past their retention and compress old logs in the background
+	jobs.StartLogSweeper(cfg.RetentionInterval)
+
+	// Index the output of finished runs for search in the background
+	jobs.StartSearchIndexer()
+
 	handlers.SetupHTTPHandlers()
 
+	srv := &http.Server{Addr: cfg.Addr(), Handler: handlers.RequireLogin(http.DefaultServeMux)}
+	srv.RegisterOnShutdown(handlers.EndStreams)
+
 	// Graceful shutdown handling
-	setupSignalHandling()
+	stopped := setupSignalHandling(srv, cfg.DrainTimeout)
 
-	log.Printf("CronCraft running at http://localhost%s", serverPort)
-	if err := http.ListenAndServe(serverPort, nil); err != nil {
+	log.Printf("CronCraft running at http://%s", displayAddr(cfg))
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 		log.Fatalf("Server failed: %v", err)
 	}
+
+	<-stopped
 }
 
-func setupSignalHandling() {
-	stop := make(chan os.Signal, 1)
+// setupSignalHandling shuts down on SIGINT or SIGTERM. The returned channel
+// is closed once shutdown has finished. A second signal exits immediately.
+func setupSignalHandling(srv *http.Server, drainTimeout time.Duration) <-chan struct{} {
+	stop := make(chan os.Signal, 2)
 	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
 
+	stopped := make(chan struct{})
 	go func() {
 		<-stop
 		log.Println("Shutting down CronCraft gracefully...")
-		shutdown()
-		os.Exit(0)
+
+		go func() {
+			<-stop
+			log.Println("Forced shutdown")
+			os.Exit(1)
+		}()
+
+		shutdown(srv, drainTimeout)
+		close(stopped)
 	}()
+
+	return stopped
 }
 
-func shutdown() {
+func shutdown(srv *http.Server, drainTimeout time.Duration) {
+	// Stop accepting requests so no new runs are triggered over HTTP
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Failed to shut down HTTP server: %v", err)
+	}
+
+	// Stop the scheduler and let running jobs finish
+	jobs.Shutdown(drainTimeout)
+
 	if db.DB != nil {
 		// Flush all pending WAL changes into the main DB
 		if _, err := db.DB.Exec("PRAGMA wal_checkpoint(FULL);"); err != nil {
@@ -63,8 +129,61 @@ func shutdown() {
 			log.Printf("Failed to close DB: %v", err)
 		}
 	}
+}
 
-	if jobs.C != nil {
-		jobs.C.Stop()
+// applyConfig hands the settings to the packages that use them and creates
+// the data and log directories
+func applyConfig(cfg *config.Config) error {
+	for _, dir := range []string{cfg.DataDir, cfg.LogsPath()} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	if cfg.TimeZone != "" {
+		loc, err := time.LoadLocation(cfg.TimeZone)
+		if err != nil {
+			return err
+		}
+		utils.ServerLocation = loc
 	}
-}
\ No newline at end of file
+
+	jobs.LogDir = cfg.LogsPath()
+	jobs.Logs = logstore.NewFS(cfg.LogsPath())
+	if cfg.LogStore == config.LogStoreS3 {
+		store, err := logstore.NewS3(logstore.S3Options{
+			Endpoint:  cfg.S3Endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			Prefix:    cfg.S3Prefix,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			PathStyle: cfg.S3PathStyle,
+			Timeout:   cfg.S3Timeout,
+			SpoolDir:  cfg.LogsPath(),
+		})
+		if err != nil {
+			return err
+		}
+		jobs.Logs = store
+	}
+	jobs.Shell = cfg.Shell
+	jobs.CompressLogsAfter = cfg.CompressLogsAfter
+	jobs.SearchFullLogs = cfg.SearchFullLogs
+	jobs.DefaultRetention = models.Retention{
+		KeepRuns:       cfg.MaxLogsPerJob,
+		KeepDays:       cfg.LogRetentionDays,
+		KeepFailedDays: cfg.FailedLogRetentionDays,
+		MaxLogMB:       cfg.MaxLogMBPerJob,
+	}
+	return nil
+}
+
+// displayAddr returns the address to reach the server at in a browser
+func displayAddr(cfg *config.Config) string {
+	host := cfg.Bind
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, strconv.Itoa(cfg.Port))
+}
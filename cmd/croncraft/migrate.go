@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/abhilashreddysh/croncraft/internal/config"
+	"github.com/abhilashreddysh/croncraft/internal/db"
+)
+
+const migrateUsage = "usage: croncraft migrate status|up"
+
+// migrateCommand runs "croncraft migrate status|up" and returns the exit
+// code. status lists the migrations and whether they were applied; up
+// applies the pending ones, as starting CronCraft would.
+func migrateCommand(cfg *config.Config, args []string) int {
+	if len(args) != 1 || (args[0] != "status" && args[0] != "up") {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		return 2
+	}
+
+	if err := db.Open(cfg.DBPath()); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open database: %v\n", err)
+		return 1
+	}
+	defer db.DB.Close()
+
+	if args[0] == "up" {
+		if err := db.Migrate(); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to migrate database: %v\n", err)
+			return 1
+		}
+	}
+
+	list, err := db.MigrationStatus()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read migrations: %v\n", err)
+		return 1
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED")
+	pending := 0
+	for _, m := range list {
+		applied := m.AppliedAt.Local().Format("2006-01-02 15:04:05 MST")
+		if m.Pending() {
+			applied = "pending"
+			pending++
+		}
+		name := m.Name
+		if !m.Known {
+			name += " (unknown to this version)"
+		}
+		fmt.Fprintf(tw, "%04d\t%s\t%s\n", m.Version, name, applied)
+	}
+	tw.Flush()
+
+	if pending > 0 {
+		fmt.Printf("%d pending, run \"croncraft migrate up\" to apply\n", pending)
+	}
+	if err := db.CheckSchemaVersion(list); errors.Is(err, db.ErrSchemaTooNew) {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
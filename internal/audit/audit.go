@@ -0,0 +1,171 @@
+// Package audit keeps the append-only record of changes to jobs and of
+// manual actions on them.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/abhilashreddysh/croncraft/internal/db"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+	"github.com/abhilashreddysh/croncraft/internal/utils"
+)
+
+// ignoredFields are job fields that are not configuration and are left out
+// of diffs
+var ignoredFields = map[string]bool{
+	"id":          true,
+	"last_run_at": true,
+	"next_runs":   true,
+	"version":     true,
+}
+
+// Record appends an event to the audit log, stamping it with the current
+// time
+func Record(e models.AuditEvent) error {
+	changes, err := json.Marshal(e.Changes)
+	if err != nil {
+		return fmt.Errorf("failed to encode changes: %w", err)
+	}
+	if e.Changes == nil {
+		changes = []byte("{}")
+	}
+
+	var runID any
+	if e.RunID != 0 {
+		runID = e.RunID
+	}
+
+	return utils.RetryDBOperation(func() error {
+		_, err := db.DB.Exec(`
+			INSERT INTO audit_events (created_at, actor, ip, action, job_id, job_name, run_id, changes)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			time.Now().UTC().Format(time.RFC3339), e.Actor, e.IP, e.Action, e.JobID, e.JobName,
+			runID, string(changes))
+		return err
+	})
+}
+
+// Diff returns the job fields that differ between before and after, keyed
+// by their JSON names. A nil before describes a created job and a nil after
+// a deleted one.
+func Diff(before, after *models.Job) (map[string]models.FieldChange, error) {
+	old, err := jobFields(before)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := jobFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := map[string]models.FieldChange{}
+	for name, value := range old {
+		if !reflect.DeepEqual(value, updated[name]) {
+			changes[name] = models.FieldChange{Before: value, After: updated[name]}
+		}
+	}
+	for name, value := range updated {
+		if _, ok := old[name]; !ok {
+			changes[name] = models.FieldChange{Before: nil, After: value}
+		}
+	}
+	return changes, nil
+}
+
+// jobFields returns the configuration fields of a job by JSON name
+func jobFields(j *models.Job) (map[string]any, error) {
+	if j == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(j)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	for name := range ignoredFields {
+		delete(fields, name)
+	}
+	return fields, nil
+}
+
+// Filter selects audit events. Zero fields match every event.
+type Filter struct {
+	Action string
+	Actor  string
+	JobID  int
+	Since  time.Time
+	Until  time.Time
+}
+
+// List returns a page of the events matching the filter, newest first,
+// and how many match in total
+func List(f Filter, limit, offset int) ([]models.AuditEvent, int, error) {
+	var where []string
+	var args []any
+	if f.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, f.Action)
+	}
+	if f.Actor != "" {
+		where = append(where, "actor = ?")
+		args = append(args, f.Actor)
+	}
+	if f.JobID != 0 {
+		where = append(where, "job_id = ?")
+		args = append(args, f.JobID)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, f.Since.UTC().Format(time.RFC3339))
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, f.Until.UTC().Format(time.RFC3339))
+	}
+
+	filter := ""
+	if len(where) > 0 {
+		filter = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM audit_events"+filter, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT id, created_at, actor, ip, action, job_id, job_name, COALESCE(run_id, 0), changes
+		FROM audit_events`+filter+" ORDER BY id DESC LIMIT ? OFFSET ?",
+		append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	events := []models.AuditEvent{}
+	for rows.Next() {
+		var e models.AuditEvent
+		var createdAt, changes string
+		if err := rows.Scan(&e.ID, &createdAt, &e.Actor, &e.IP, &e.Action, &e.JobID, &e.JobName,
+			&e.RunID, &changes); err != nil {
+			return nil, 0, err
+		}
+		e.Time, _ = time.Parse(time.RFC3339, createdAt)
+		if err := json.Unmarshal([]byte(changes), &e.Changes); err != nil {
+			return nil, 0, fmt.Errorf("failed to decode changes of event %d: %w", e.ID, err)
+		}
+		if len(e.Changes) == 0 {
+			e.Changes = nil
+		}
+		events = append(events, e)
+	}
+	return events, total, rows.Err()
+}
@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+
+	"github.com/abhilashreddysh/croncraft/internal/db"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+	"github.com/abhilashreddysh/croncraft/internal/utils"
+)
+
+// ErrGrantAboveRole is returned for job grants above the account's own role
+var ErrGrantAboveRole = errors.New("a job grant cannot exceed the account's own role")
+
+// SetGrant gives an account a role on one job, at most its own role. Once
+// an account has been granted a job it only sees the jobs it is granted,
+// even after the grants are removed, until AllowAllJobs. An empty role
+// removes the grant.
+func SetGrant(userID, jobID int, role string) error {
+	if role != "" && !models.ValidRole(role) {
+		return ErrInvalidRole
+	}
+
+	return utils.RetryDBOperation(func() error {
+		if role == "" {
+			_, err := db.DB.Exec("DELETE FROM job_grants WHERE user_id = ? AND job_id = ?", userID, jobID)
+			return err
+		}
+
+		var u models.User
+		err := db.DB.QueryRow("SELECT role FROM users WHERE id = ?", userID).Scan(&u.Role)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUserNotFound
+		} else if err != nil {
+			return err
+		}
+		if !u.Can(role, 0) {
+			return ErrGrantAboveRole
+		}
+
+		tx, err := db.DB.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.Exec("UPDATE users SET job_scoped = 1 WHERE id = ?", userID); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO job_grants (user_id, job_id, role) VALUES (?, ?, ?)
+			ON CONFLICT(user_id, job_id) DO UPDATE SET role = excluded.role`,
+			userID, jobID, role)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// AllowAllJobs removes the job grants of an account so that it sees every
+// job again, with its own role
+func AllowAllJobs(userID int) error {
+	return utils.RetryDBOperation(func() error {
+		tx, err := db.DB.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		res, err := tx.Exec("UPDATE users SET job_scoped = 0 WHERE id = ?", userID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrUserNotFound
+		}
+		if _, err := tx.Exec("DELETE FROM job_grants WHERE user_id = ?", userID); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// capGrants lowers the job grants of an account above role to role
+func capGrants(tx *sql.Tx, userID int, role string) error {
+	allowed := models.Roles[:slices.Index(models.Roles, role)+1]
+	query := "UPDATE job_grants SET role = ? WHERE user_id = ? AND role NOT IN (" +
+		strings.TrimSuffix(strings.Repeat("?, ", len(allowed)), ", ") + ")"
+	args := []any{role, userID}
+	for _, r := range allowed {
+		args = append(args, r)
+	}
+	_, err := tx.Exec(query, args...)
+	return err
+}
+
+// loadGrants returns the job grants of an account
+func loadGrants(userID int) (map[int]string, error) {
+	rows, err := db.DB.Query("SELECT job_id, role FROM job_grants WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	grants := map[int]string{}
+	for rows.Next() {
+		var jobID int
+		var role string
+		if err := rows.Scan(&jobID, &role); err != nil {
+			return nil, err
+		}
+		grants[jobID] = role
+	}
+	return grants, rows.Err()
+}
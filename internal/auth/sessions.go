@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/abhilashreddysh/croncraft/internal/db"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+	"github.com/abhilashreddysh/croncraft/internal/utils"
+)
+
+const (
+	// SessionCookie names the cookie holding the session token
+	SessionCookie = "croncraft_session"
+
+	// SessionLifetime is how long a login lasts
+	SessionLifetime = 7 * 24 * time.Hour
+)
+
+// ErrNoSession is returned for tokens that match no live session
+var ErrNoSession = errors.New("no such session")
+
+// CreateSession starts a session for the user and returns its token. Only a
+// hash of the token is stored.
+func CreateSession(userID int) (string, time.Time, error) {
+	token := rand.Text()
+	now := time.Now().UTC()
+	expires := now.Add(SessionLifetime)
+
+	err := utils.RetryDBOperation(func() error {
+		if _, err := db.DB.Exec("DELETE FROM sessions WHERE expires_at < ?", now.Format(time.RFC3339)); err != nil {
+			return err
+		}
+		_, err := db.DB.Exec(
+			"INSERT INTO sessions (token_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)",
+			hashToken(token), userID, now.Format(time.RFC3339), expires.Format(time.RFC3339),
+		)
+		return err
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expires, nil
+}
+
+// SessionUser returns the account signed in with the session token
+func SessionUser(token string) (*models.User, error) {
+	var u models.User
+	var expires string
+	err := db.DB.QueryRow(`
+		SELECT u.id, u.username, u.role, u.job_scoped, s.expires_at
+		FROM sessions s JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ?`, hashToken(token)).Scan(&u.ID, &u.Username, &u.Role, &u.JobScoped, &expires)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNoSession
+	} else if err != nil {
+		return nil, err
+	}
+
+	if t, err := time.Parse(time.RFC3339, expires); err != nil || time.Now().After(t) {
+		_ = DeleteSession(token)
+		return nil, ErrNoSession
+	}
+
+	if u.Grants, err = loadGrants(u.ID); err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// DeleteSession ends the session with the token
+func DeleteSession(token string) error {
+	return utils.RetryDBOperation(func() error {
+		_, err := db.DB.Exec("DELETE FROM sessions WHERE token_hash = ?", hashToken(token))
+		return err
+	})
+}
+
+// DeleteOtherSessions ends every session of the user except the one with
+// the token, e.g. after a password change
+func DeleteOtherSessions(userID int, token string) error {
+	return utils.RetryDBOperation(func() error {
+		_, err := db.DB.Exec("DELETE FROM sessions WHERE user_id = ? AND token_hash != ?", userID, hashToken(token))
+		return err
+	})
+}
+
+// CSRFToken returns the CSRF token of a session. It is derived from the
+// session token, so it lasts exactly as long as the session and needs no
+// storage of its own.
+func CSRFToken(sessionToken string) string {
+	sum := sha256.Sum256([]byte("csrf:" + sessionToken))
+	return hex.EncodeToString(sum[:])
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type userKey struct{}
+
+// WithUser returns a context carrying the signed in account
+func WithUser(ctx context.Context, u *models.User) context.Context {
+	return context.WithValue(ctx, userKey{}, u)
+}
+
+// UserFromContext returns the signed in account, or nil
+func UserFromContext(ctx context.Context) *models.User {
+	u, _ := ctx.Value(userKey{}).(*models.User)
+	return u
+}
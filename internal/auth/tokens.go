@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/abhilashreddysh/croncraft/internal/db"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+	"github.com/abhilashreddysh/croncraft/internal/utils"
+)
+
+// TokenPrefix starts every API token so they are easy to recognise, e.g. by
+// secret scanners
+const TokenPrefix = "cc_"
+
+var (
+	ErrInvalidToken  = errors.New("invalid or expired API token")
+	ErrInvalidScope  = errors.New("scope must be trigger, read or admin")
+	ErrTokenNotFound = errors.New("no such API token")
+)
+
+// CreateToken mints an API token limited to scope and, unless jobIDs is
+// empty, to those jobs. A zero expires means the token does not expire.
+// Only a hash of the token is stored, so it cannot be shown again.
+func CreateToken(name, scope string, jobIDs []int, expires time.Time, createdBy string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", errors.New("name is required")
+	}
+	if models.ScopeRole(scope) == "" {
+		return "", ErrInvalidScope
+	}
+	if !expires.IsZero() && expires.Before(time.Now()) {
+		return "", errors.New("expiry must be in the future")
+	}
+
+	var expiresAt sql.NullString
+	if !expires.IsZero() {
+		expiresAt = sql.NullString{String: expires.UTC().Format(time.RFC3339), Valid: true}
+	}
+
+	token := TokenPrefix + rand.Text()
+	err := utils.RetryDBOperation(func() error {
+		tx, err := db.DB.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		res, err := tx.Exec(`
+			INSERT INTO api_tokens (name, token_hash, scope, job_scoped, created_by, created_at, expires_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			name, hashToken(token), scope, len(jobIDs) > 0, createdBy,
+			time.Now().UTC().Format(time.RFC3339), expiresAt)
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		for _, jobID := range jobIDs {
+			if _, err := tx.Exec("INSERT INTO api_token_jobs (token_id, job_id) VALUES (?, ?)", id, jobID); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// ListTokens returns every API token, newest first
+func ListTokens() ([]models.APIToken, error) {
+	rows, err := db.DB.Query(`
+		SELECT id, name, scope, job_scoped, created_by, created_at,
+		       COALESCE(expires_at, ''), COALESCE(last_used_at, '')
+		FROM api_tokens ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []models.APIToken
+	for rows.Next() {
+		var t models.APIToken
+		var createdAt, expiresAt, lastUsed string
+		if err := rows.Scan(&t.ID, &t.Name, &t.Scope, &t.JobScoped, &t.CreatedBy, &createdAt,
+			&expiresAt, &lastUsed); err != nil {
+			return nil, err
+		}
+		t.CreatedAt = parseStoredTime(createdAt)
+		t.ExpiresAt = parseStoredTime(expiresAt)
+		t.LastUsed = parseStoredTime(lastUsed)
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range tokens {
+		if tokens[i].JobScoped {
+			if tokens[i].JobIDs, err = tokenJobs(tokens[i].ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// RevokeToken deletes an API token so it can no longer be used
+func RevokeToken(id int) error {
+	return utils.RetryDBOperation(func() error {
+		res, err := db.DB.Exec("DELETE FROM api_tokens WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrTokenNotFound
+		}
+		return nil
+	})
+}
+
+// TokenUser returns the stand-in account for a request made with an API
+// token, limited to the token's scope and jobs, and records the token as
+// used
+func TokenUser(token string) (*models.User, error) {
+	var id int
+	var name, scope, expiresAt string
+	var jobScoped bool
+	err := db.DB.QueryRow(`
+		SELECT id, name, scope, job_scoped, COALESCE(expires_at, '')
+		FROM api_tokens WHERE token_hash = ?`, hashToken(token)).
+		Scan(&id, &name, &scope, &jobScoped, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	if expiresAt != "" && time.Now().After(parseStoredTime(expiresAt)) {
+		return nil, ErrInvalidToken
+	}
+
+	u := &models.User{Username: "token:" + name, Role: models.ScopeRole(scope), Scope: scope}
+	if jobScoped {
+		jobIDs, err := tokenJobs(id)
+		if err != nil {
+			return nil, err
+		}
+		// The token acts only on its jobs, with no access beyond them
+		u.Role = models.RoleViewer
+		u.JobScoped = true
+		u.Grants = map[int]string{}
+		for _, jobID := range jobIDs {
+			u.Grants[jobID] = models.ScopeRole(scope)
+		}
+	}
+
+	err = utils.RetryDBOperation(func() error {
+		_, err := db.DB.Exec("UPDATE api_tokens SET last_used_at = ? WHERE id = ?",
+			time.Now().UTC().Format(time.RFC3339), id)
+		return err
+	})
+	if err != nil {
+		log.Printf("Failed to record use of API token %q: %v", name, err)
+	}
+	return u, nil
+}
+
+// tokenJobs returns the jobs an API token is limited to
+func tokenJobs(tokenID int) ([]int, error) {
+	rows, err := db.DB.Query("SELECT job_id FROM api_token_jobs WHERE token_id = ? ORDER BY job_id", tokenID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobIDs := []int{}
+	for rows.Next() {
+		var jobID int
+		if err := rows.Scan(&jobID); err != nil {
+			return nil, err
+		}
+		jobIDs = append(jobIDs, jobID)
+	}
+	return jobIDs, rows.Err()
+}
+
+// parseStoredTime parses an RFC 3339 time column, returning the zero time
+// for empty or malformed values
+func parseStoredTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t.Local()
+}
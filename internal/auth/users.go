@@ -0,0 +1,249 @@
+package auth
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/abhilashreddysh/croncraft/internal/db"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+	"github.com/abhilashreddysh/croncraft/internal/utils"
+)
+
+// MinPasswordLength is the shortest password accepted for an account
+const MinPasswordLength = 8
+
+// adminUsername is the account created when the database has no users
+const adminUsername = "admin"
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrPasswordTooShort   = fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	ErrInvalidRole        = errors.New("role must be viewer, operator or admin")
+	ErrLastAdmin          = errors.New("at least one admin account must remain")
+	ErrUserNotFound       = errors.New("no such account")
+)
+
+// dummyHash is compared against when a username does not exist, so failed
+// logins take as long whether or not the account exists
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("croncraft"), bcrypt.DefaultCost)
+
+// EnsureAdmin creates the admin account with a random password when the
+// database has no users yet, and logs the password once. Databases from
+// before roles existed get their oldest account promoted to admin.
+func EnsureAdmin() error {
+	var count, admins int
+	err := db.DB.QueryRow("SELECT COUNT(*), COUNT(CASE WHEN role = ? THEN 1 END) FROM users",
+		models.RoleAdmin).Scan(&count, &admins)
+	if err != nil {
+		return fmt.Errorf("failed to count users: %w", err)
+	}
+
+	if count > 0 {
+		if admins > 0 {
+			return nil
+		}
+		var u models.User
+		if err := db.DB.QueryRow("SELECT id, username FROM users ORDER BY id LIMIT 1").Scan(&u.ID, &u.Username); err != nil {
+			return fmt.Errorf("failed to find an account to promote: %w", err)
+		}
+		if err := SetRole(u.ID, models.RoleAdmin); err != nil {
+			return fmt.Errorf("failed to promote %q to admin: %w", u.Username, err)
+		}
+		log.Printf("No admin account found, made %q an admin", u.Username)
+		return nil
+	}
+
+	password := rand.Text()
+	if _, err := CreateUser(adminUsername, password, models.RoleAdmin); err != nil {
+		return fmt.Errorf("failed to create admin account: %w", err)
+	}
+
+	log.Printf("Created account %q with password %s - change it from the Account page after signing in",
+		adminUsername, password)
+	return nil
+}
+
+// CreateUser adds an account with the given password and role
+func CreateUser(username, password, role string) (*models.User, error) {
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, errors.New("username is required")
+	}
+	if !models.ValidRole(role) {
+		return nil, ErrInvalidRole
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	var id int64
+	err = utils.RetryDBOperation(func() error {
+		res, err := db.DB.Exec("INSERT INTO users (username, password_hash, role) VALUES (?, ?, ?)",
+			username, hash, role)
+		if err != nil {
+			return err
+		}
+		id, err = res.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &models.User{ID: int(id), Username: username, Role: role}, nil
+}
+
+// ListUsers returns every account with its job grants, ordered by username
+func ListUsers() ([]models.User, error) {
+	rows, err := db.DB.Query("SELECT id, username, role, job_scoped, COALESCE(created_at, '') FROM users ORDER BY username")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var u models.User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Role, &u.JobScoped, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range users {
+		if users[i].Grants, err = loadGrants(users[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return users, nil
+}
+
+// SetRole changes the role of an account, lowering its job grants above the
+// new role. The last admin cannot be demoted.
+func SetRole(userID int, role string) error {
+	if !models.ValidRole(role) {
+		return ErrInvalidRole
+	}
+
+	return utils.RetryDBOperation(func() error {
+		if role != models.RoleAdmin {
+			if err := checkNotLastAdmin(userID); err != nil {
+				return err
+			}
+		}
+
+		tx, err := db.DB.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		res, err := tx.Exec("UPDATE users SET role = ? WHERE id = ?", role, userID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrUserNotFound
+		}
+		// Job grants never give more than the account's own role
+		if err := capGrants(tx, userID, role); err != nil {
+			return err
+		}
+		return tx.Commit()
+	})
+}
+
+// DeleteUser removes an account along with its sessions and grants. The
+// last admin cannot be deleted.
+func DeleteUser(userID int) error {
+	return utils.RetryDBOperation(func() error {
+		if err := checkNotLastAdmin(userID); err != nil {
+			return err
+		}
+		res, err := db.DB.Exec("DELETE FROM users WHERE id = ?", userID)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrUserNotFound
+		}
+		return nil
+	})
+}
+
+// checkNotLastAdmin returns ErrLastAdmin when the account is the only admin
+func checkNotLastAdmin(userID int) error {
+	var others int
+	var role string
+	err := db.DB.QueryRow(`
+		SELECT role, (SELECT COUNT(*) FROM users WHERE role = ? AND id != ?)
+		FROM users WHERE id = ?`, models.RoleAdmin, userID, userID).Scan(&role, &others)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+	if role == models.RoleAdmin && others == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// Authenticate returns the account matching the username and password
+func Authenticate(username, password string) (*models.User, error) {
+	var u models.User
+	var hash string
+	err := db.DB.QueryRow("SELECT id, username, role, password_hash FROM users WHERE username = ?",
+		strings.TrimSpace(username)).Scan(&u.ID, &u.Username, &u.Role, &hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+		return nil, ErrInvalidCredentials
+	}
+	return &u, nil
+}
+
+// ChangePassword replaces the password of an account after checking its
+// current one
+func ChangePassword(u *models.User, current, password string) error {
+	if _, err := Authenticate(u.Username, current); err != nil {
+		return errors.New("current password is incorrect")
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+
+	return utils.RetryDBOperation(func() error {
+		_, err := db.DB.Exec("UPDATE users SET password_hash = ? WHERE id = ?", hash, u.ID)
+		return err
+	})
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", ErrPasswordTooShort
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
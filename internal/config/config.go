@@ -0,0 +1,277 @@
+// Package config loads the settings of CronCraft. Each setting comes from,
+// in order of precedence:
+//
+//  1. a command line flag, e.g. -port 9090
+//  2. an environment variable, e.g. CRONCRAFT_PORT=9090
+//  3. the config file named by -config or CRONCRAFT_CONFIG, in YAML
+//     (.yaml, .yml) or TOML (.toml), e.g. port: 9090
+//  4. the built-in default
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// envPrefix starts the environment variable of every setting
+const envPrefix = "CRONCRAFT_"
+
+// Config holds the settings of CronCraft. Keys in the config file are the
+// yaml and toml names below; flags use the same names with dashes, and
+// environment variables the upper-cased names with envPrefix.
+type Config struct {
+	DataDir string `yaml:"data_dir" toml:"data_dir"` // directory the database and logs are kept in
+	DBFile  string `yaml:"db_file" toml:"db_file"`   // relative to DataDir unless absolute
+	LogsDir string `yaml:"logs_dir" toml:"logs_dir"` // relative to DataDir unless absolute
+
+	Bind string `yaml:"bind" toml:"bind"` // address to listen on, empty for all interfaces
+	Port int    `yaml:"port" toml:"port"`
+
+	Shell    string `yaml:"shell" toml:"shell"`       // runs job commands as Shell -c command
+	TimeZone string `yaml:"timezone" toml:"timezone"` // zone of schedules without one, empty for the system's
+
+	DrainTimeout time.Duration `yaml:"drain_timeout" toml:"drain_timeout"` // wait for running jobs on shutdown
+
+	// Retention of finished runs, for jobs that do not set their own; 0 turns
+	// a rule off
+	MaxLogsPerJob          int           `yaml:"max_logs_per_job" toml:"max_logs_per_job"`                   // newest runs kept
+	LogRetentionDays       int           `yaml:"log_retention_days" toml:"log_retention_days"`               // runs kept for this many days
+	FailedLogRetentionDays int           `yaml:"failed_log_retention_days" toml:"failed_log_retention_days"` // failed runs kept for this many days
+	MaxLogMBPerJob         int           `yaml:"max_log_mb_per_job" toml:"max_log_mb_per_job"`               // total size of a job's logs
+	RetentionInterval      time.Duration `yaml:"retention_interval" toml:"retention_interval"`               // how often old runs are removed
+	CompressLogsAfter      time.Duration `yaml:"compress_logs_after" toml:"compress_logs_after"`             // age of finished runs whose logs are gzipped
+
+	// SearchFullLogs indexes the whole log of each run for search rather
+	// than the output preview kept in the database
+	SearchFullLogs bool `yaml:"search_full_logs" toml:"search_full_logs"`
+
+	// Where run logs are kept: fs keeps them in LogsDir, s3 in an
+	// S3-compatible bucket, spooling them in LogsDir while they are written
+	LogStore    string        `yaml:"log_store" toml:"log_store"`
+	S3Endpoint  string        `yaml:"s3_endpoint" toml:"s3_endpoint"` // e.g. https://s3.eu-central-1.amazonaws.com
+	S3Region    string        `yaml:"s3_region" toml:"s3_region"`
+	S3Bucket    string        `yaml:"s3_bucket" toml:"s3_bucket"`
+	S3Prefix    string        `yaml:"s3_prefix" toml:"s3_prefix"` // put in front of log names
+	S3AccessKey string        `yaml:"s3_access_key" toml:"s3_access_key"`
+	S3SecretKey string        `yaml:"s3_secret_key" toml:"s3_secret_key"`
+	S3PathStyle bool          `yaml:"s3_path_style" toml:"s3_path_style"` // bucket in the path, as MinIO expects
+	S3Timeout   time.Duration `yaml:"s3_timeout" toml:"s3_timeout"`       // how long a request may stall
+}
+
+// Log stores
+const (
+	LogStoreFS = "fs"
+	LogStoreS3 = "s3"
+)
+
+// Default returns the settings used when nothing else is configured
+func Default() *Config {
+	return &Config{
+		DataDir:      ".",
+		DBFile:       "croncraft.db",
+		LogsDir:      "logs",
+		Port:         8080,
+		Shell:        "sh",
+		DrainTimeout: 30 * time.Second,
+
+		MaxLogsPerJob:     10,
+		RetentionInterval: 10 * time.Minute,
+		CompressLogsAfter: 24 * time.Hour,
+
+		LogStore:  LogStoreFS,
+		S3Region:  "us-east-1",
+		S3Timeout: 30 * time.Second,
+	}
+}
+
+// Addr returns the address the HTTP server listens on
+func (c *Config) Addr() string {
+	return net.JoinHostPort(c.Bind, strconv.Itoa(c.Port))
+}
+
+// DBPath returns the path of the database file
+func (c *Config) DBPath() string {
+	return c.inDataDir(c.DBFile)
+}
+
+// LogsPath returns the path of the directory run logs are written to
+func (c *Config) LogsPath() string {
+	return c.inDataDir(c.LogsDir)
+}
+
+func (c *Config) inDataDir(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(c.DataDir, path)
+}
+
+// Load reads the settings from the config file, the environment and the
+// command line arguments. It returns the arguments left after the flags,
+// such as a subcommand. Invalid flags print the usage and exit.
+func Load(args []string) (*Config, []string, error) {
+	cfg := Default()
+	var file string
+
+	fs := flag.NewFlagSet("croncraft", flag.ExitOnError)
+	fs.StringVar(&file, "config", "", "config file to read, in YAML or TOML")
+	fs.StringVar(&cfg.DataDir, "data-dir", cfg.DataDir, "directory the database and logs are kept in")
+	fs.StringVar(&cfg.DBFile, "db-file", cfg.DBFile, "database file, relative to the data directory")
+	fs.StringVar(&cfg.LogsDir, "logs-dir", cfg.LogsDir, "directory for run logs, relative to the data directory")
+	fs.StringVar(&cfg.Bind, "bind", cfg.Bind, "address to listen on, empty for all interfaces")
+	fs.IntVar(&cfg.Port, "port", cfg.Port, "port to listen on")
+	fs.StringVar(&cfg.Shell, "shell", cfg.Shell, "shell that runs job commands with -c")
+	fs.StringVar(&cfg.TimeZone, "timezone", cfg.TimeZone, "time zone of schedules without one, empty for the system's")
+	fs.DurationVar(&cfg.DrainTimeout, "drain-timeout", cfg.DrainTimeout,
+		"how long to wait on shutdown for running jobs before interrupting them")
+	fs.IntVar(&cfg.MaxLogsPerJob, "max-logs-per-job", cfg.MaxLogsPerJob, "newest runs kept for each job, 0 for no limit")
+	fs.IntVar(&cfg.LogRetentionDays, "log-retention-days", cfg.LogRetentionDays, "days runs are kept for, 0 for no limit")
+	fs.IntVar(&cfg.FailedLogRetentionDays, "failed-log-retention-days", cfg.FailedLogRetentionDays,
+		"days failed runs are kept for, even past the other limits")
+	fs.IntVar(&cfg.MaxLogMBPerJob, "max-log-mb-per-job", cfg.MaxLogMBPerJob, "total size of each job's logs in MB, 0 for no limit")
+	fs.DurationVar(&cfg.RetentionInterval, "retention-interval", cfg.RetentionInterval, "how often runs past their retention are removed")
+	fs.DurationVar(&cfg.CompressLogsAfter, "compress-logs-after", cfg.CompressLogsAfter,
+		"how long after a run finished its log is compressed, 0 to never compress")
+	fs.BoolVar(&cfg.SearchFullLogs, "search-full-logs", cfg.SearchFullLogs, "index the whole log of runs for search, not only the output preview")
+	fs.StringVar(&cfg.LogStore, "log-store", cfg.LogStore, "where run logs are kept, fs or s3")
+	fs.StringVar(&cfg.S3Endpoint, "s3-endpoint", cfg.S3Endpoint, "URL of the S3-compatible service")
+	fs.StringVar(&cfg.S3Region, "s3-region", cfg.S3Region, "region of the S3 bucket")
+	fs.StringVar(&cfg.S3Bucket, "s3-bucket", cfg.S3Bucket, "S3 bucket logs are kept in")
+	fs.StringVar(&cfg.S3Prefix, "s3-prefix", cfg.S3Prefix, "prefix of log names in the S3 bucket")
+	fs.StringVar(&cfg.S3AccessKey, "s3-access-key", cfg.S3AccessKey, "S3 access key")
+	fs.StringVar(&cfg.S3SecretKey, "s3-secret-key", cfg.S3SecretKey, "S3 secret key, better set in the environment")
+	fs.BoolVar(&cfg.S3PathStyle, "s3-path-style", cfg.S3PathStyle, "name the S3 bucket in the path instead of the host name")
+	fs.DurationVar(&cfg.S3Timeout, "s3-timeout", cfg.S3Timeout, "how long a request to S3 may go without progress before it fails")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: croncraft [flags] [migrate status|up]\n\n")
+		fmt.Fprintf(fs.Output(), "Flags can also be set in the config file, or in the environment as %s<NAME>.\n\n", envPrefix)
+		fs.PrintDefaults()
+	}
+
+	// The flags are parsed first only to find the config file; the other
+	// layers are then applied in order of precedence, lowest first
+	fs.Parse(args)
+	given := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = f.Value.String() })
+
+	if file == "" {
+		file = os.Getenv(envPrefix + "CONFIG")
+	}
+	*cfg = *Default()
+	if file != "" {
+		if err := cfg.readFile(file); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := envName(f.Name)
+		if v, ok := os.LookupEnv(name); ok && err == nil && f.Name != "config" {
+			if setErr := fs.Set(f.Name, v); setErr != nil {
+				err = fmt.Errorf("invalid %s: %w", name, setErr)
+			}
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for name, v := range given {
+		fs.Set(name, v)
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// envName returns the environment variable of a flag
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// readFile reads the settings in a YAML or TOML config file over c.
+// Unknown keys are an error, so that typos do not go unnoticed.
+func (c *Config) readFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.Decode(string(data), c)
+		if err != nil {
+			return fmt.Errorf("invalid config file %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("invalid config file %s: unknown setting %q", path, undecoded[0].String())
+		}
+	default:
+		return fmt.Errorf("config file %s must end in .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	switch {
+	case c.DataDir == "":
+		return errors.New("data_dir must not be empty")
+	case c.DBFile == "":
+		return errors.New("db_file must not be empty")
+	case c.LogsDir == "":
+		return errors.New("logs_dir must not be empty")
+	case c.Port < 1 || c.Port > 65535:
+		return fmt.Errorf("port must be between 1 and 65535, got %d", c.Port)
+	case c.Shell == "":
+		return errors.New("shell must not be empty")
+	case c.DrainTimeout < 0:
+		return errors.New("drain_timeout must not be negative")
+	case c.MaxLogsPerJob < 0:
+		return fmt.Errorf("max_logs_per_job must not be negative, got %d", c.MaxLogsPerJob)
+	case c.LogRetentionDays < 0:
+		return fmt.Errorf("log_retention_days must not be negative, got %d", c.LogRetentionDays)
+	case c.FailedLogRetentionDays < 0:
+		return fmt.Errorf("failed_log_retention_days must not be negative, got %d", c.FailedLogRetentionDays)
+	case c.MaxLogMBPerJob < 0:
+		return fmt.Errorf("max_log_mb_per_job must not be negative, got %d", c.MaxLogMBPerJob)
+	case c.RetentionInterval < time.Second:
+		return errors.New("retention_interval must be at least 1s")
+	case c.CompressLogsAfter < 0:
+		return errors.New("compress_logs_after must not be negative")
+	case c.LogStore != LogStoreFS && c.LogStore != LogStoreS3:
+		return fmt.Errorf("log_store must be %s or %s, got %q", LogStoreFS, LogStoreS3, c.LogStore)
+	case c.LogStore == LogStoreS3 && (c.S3Endpoint == "" || c.S3Bucket == "" || c.S3Region == ""):
+		return errors.New("the s3 log store needs s3_endpoint, s3_bucket and s3_region")
+	case c.LogStore == LogStoreS3 && (c.S3AccessKey == "" || c.S3SecretKey == ""):
+		return errors.New("the s3 log store needs s3_access_key and s3_secret_key")
+	case c.S3Timeout < time.Second:
+		return errors.New("s3_timeout must be at least 1s")
+	}
+	if c.TimeZone != "" {
+		if _, err := time.LoadLocation(c.TimeZone); err != nil {
+			return fmt.Errorf("unknown timezone %q", c.TimeZone)
+		}
+	}
+	return nil
+}
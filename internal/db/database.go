@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	_ "modernc.org/sqlite"
 
@@ -12,11 +13,22 @@ import (
 	"github.com/abhilashreddysh/croncraft/internal/utils"
 )
 
-const (MaxLogsPerJob = 10)
+// nextRunCount is how many upcoming runs are listed for each job
+const nextRunCount = 5
 
 var DB *sql.DB
 
+// InitializeDatabase opens the database and brings its schema up to date.
+// It refuses databases written by a newer version of CronCraft.
 func InitializeDatabase(dbFile string) error {
+	if err := Open(dbFile); err != nil {
+		return err
+	}
+	return Migrate()
+}
+
+// Open opens the database without touching its schema
+func Open(dbFile string) error {
 	// Create DB file if it doesn't exist
 	if _, err := os.Stat(dbFile); os.IsNotExist(err) {
 		file, err := os.Create(dbFile)
@@ -52,45 +64,23 @@ func InitializeDatabase(dbFile string) error {
 		log.Printf("Failed to enable foreign keys: %v", err)
 	}
 
-	// Create tables if they don't exist
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS jobs (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT NOT NULL,
-			schedule TEXT NOT NULL,
-			command TEXT NOT NULL,
-			status TEXT NOT NULL,
-			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		)`,
-		`CREATE TRIGGER IF NOT EXISTS trg_jobs_updated_at
-		AFTER UPDATE ON jobs
-		FOR EACH ROW
-		BEGIN
-			UPDATE jobs
-			SET updated_at = CURRENT_TIMESTAMP
-			WHERE id = OLD.id;
-		END;`,
-		`CREATE TABLE IF NOT EXISTS job_runs (
-			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			job_id INTEGER NOT NULL,
-			run_at TEXT NOT NULL,
-			status TEXT NOT NULL,
-			output TEXT,
-			duration_ms INT,
-			FOREIGN KEY(job_id) REFERENCES jobs(id) ON DELETE CASCADE
-		)`,
-		"CREATE INDEX IF NOT EXISTS idx_job_runs_job_id ON job_runs(job_id)",
-		"CREATE INDEX IF NOT EXISTS idx_job_runs_run_at ON job_runs(run_at DESC)",
-	}
+	return nil
+}
 
-	for _, query := range queries {
-		if _, err := DB.Exec(query); err != nil {
-			return fmt.Errorf("failed to execute query %s: %w", query, err)
-		}
+// jobColumns lists the job definition columns, in the order of jobFields
+const jobColumns = `j.id, j.name, j.schedule, j.command, j.status, j.timeout_seconds,
+	j.concurrency_policy, j.max_attempts, j.retry_backoff, j.retry_delay_seconds, j.retry_exit_codes,
+	j.rerun_interrupted, j.misfire_policy, j.misfire_max, j.timezone, j.version,
+	j.keep_runs, j.keep_days, j.keep_failed_days, j.max_log_mb`
+
+// jobFields returns the scan destinations for jobColumns
+func jobFields(j *models.Job) []any {
+	return []any{
+		&j.ID, &j.Name, &j.Schedule, &j.Command, &j.Status, &j.TimeoutSeconds,
+		&j.Concurrency, &j.MaxAttempts, &j.RetryBackoff, &j.RetryDelaySeconds, &j.RetryExitCodes,
+		&j.RerunInterrupted, &j.MisfirePolicy, &j.MisfireMax, &j.TimeZone, &j.Version,
+		&j.KeepRuns, &j.KeepDays, &j.KeepFailedDays, &j.MaxLogMB,
 	}
-
-	return nil
 }
 
 func GetJobsFromDB() ([]models.Job, error) {
@@ -98,7 +88,7 @@ func GetJobsFromDB() ([]models.Job, error) {
 
 	err := utils.RetryDBOperation(func() error {
 		rows, err := DB.Query(`
-    SELECT j.id, j.name, j.schedule, j.command, j.status,
+    SELECT `+jobColumns+`,
            COALESCE((
                SELECT MAX(r.run_at)
                FROM job_runs r
@@ -114,11 +104,11 @@ func GetJobsFromDB() ([]models.Job, error) {
 		for rows.Next() {
 			var j models.Job
 			var lastRun sql.NullString // or sql.NullTime if it's a DATETIME
-			if err := rows.Scan(&j.ID, &j.Name, &j.Schedule, &j.Command, &j.Status, &lastRun); err != nil {
+			if err := rows.Scan(append(jobFields(&j), &lastRun)...); err != nil {
 				log.Printf("Failed to scan job row: %v", err)
 				continue
 			}
-			j.LastRun = utils.NullTimeAgo(lastRun)
+			setRunTimes(&j, lastRun)
 			jobs = append(jobs, j)
 		}
 		return rows.Err()
@@ -131,4 +121,43 @@ func GetJobsFromDB() ([]models.Job, error) {
 	return jobs, nil
 }
 
+// GetJob returns a single job by ID, or sql.ErrNoRows if it does not exist
+func GetJob(id int) (*models.Job, error) {
+	var j models.Job
+	var lastRun, created, updated sql.NullString
+
+	err := DB.QueryRow(`
+		SELECT `+jobColumns+`, j.created_at, j.updated_at,
+		       COALESCE((SELECT MAX(r.run_at) FROM job_runs r WHERE r.job_id = j.id), '') AS last_run
+		FROM jobs j WHERE j.id = ?`, id).
+		Scan(append(jobFields(&j), &created, &updated, &lastRun)...)
+	if err != nil {
+		return nil, err
+	}
+
+	j.CreatedAt = utils.NullTimeAgo(created)
+	j.UpdatedAt = utils.NullTimeAgo(updated)
+	setRunTimes(&j, lastRun)
+
+	return &j, nil
+}
+
+// setRunTimes fills in when the job last ran and will next run, in the
+// job's time zone
+func setRunTimes(j *models.Job, lastRun sql.NullString) {
+	j.LastRun = utils.NullTimeAgo(lastRun)
 
+	loc := utils.LoadLocation(j.TimeZone)
+	if t, err := time.Parse(time.RFC3339, lastRun.String); err == nil {
+		j.LastRunAt = t.In(loc)
+	}
+
+	if !j.Status {
+		return
+	}
+	if schedule, err := utils.ParseSchedule(j.Schedule, j.TimeZone); err == nil {
+		for _, t := range utils.NextRuns(schedule, time.Now(), nextRunCount) {
+			j.NextRuns = append(j.NextRuns, t.In(loc))
+		}
+	}
+}
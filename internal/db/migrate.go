@@ -0,0 +1,267 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// ErrSchemaTooNew is returned for databases migrated by a newer version of
+// CronCraft, which this version could damage
+var ErrSchemaTooNew = errors.New("database schema is newer than this version of CronCraft supports")
+
+// Migration is one numbered change to the schema, embedded from a
+// migrations/NNNN_name.sql file. Migrations are applied in order, each in a
+// transaction of its own, and recorded in the schema_migrations table.
+type Migration struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time // zero while the migration is pending
+	Known     bool      // false for migrations recorded by a newer version
+
+	sql string
+}
+
+// Pending reports whether the migration has not been applied yet
+func (m Migration) Pending() bool {
+	return m.AppliedAt.IsZero()
+}
+
+// migrations returns the embedded migrations ordered by version
+func migrations() ([]Migration, error) {
+	files, err := fs.Glob(migrationsFS, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var list []Migration
+	for _, file := range files {
+		base := strings.TrimSuffix(path.Base(file), ".sql")
+		num, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil || version < 1 {
+			return nil, fmt.Errorf("migration %s is not named NNNN_name.sql", file)
+		}
+
+		data, err := migrationsFS.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, Migration{Version: version, Name: name, Known: true, sql: string(data)})
+	}
+
+	slices.SortFunc(list, func(a, b Migration) int { return a.Version - b.Version })
+	for i := 1; i < len(list); i++ {
+		if list[i].Version == list[i-1].Version {
+			return nil, fmt.Errorf("two migrations have version %d", list[i].Version)
+		}
+	}
+	return list, nil
+}
+
+// MigrationStatus lists every migration with when it was applied. Versions
+// recorded in the database that this version does not know come last.
+func MigrationStatus() ([]Migration, error) {
+	list, err := migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range list {
+		if m, ok := applied[list[i].Version]; ok {
+			list[i].AppliedAt = m.AppliedAt
+			delete(applied, list[i].Version)
+		}
+	}
+	for _, m := range applied {
+		list = append(list, m)
+	}
+	slices.SortFunc(list, func(a, b Migration) int { return a.Version - b.Version })
+	return list, nil
+}
+
+// Migrate applies the pending migrations. It refuses to touch a database
+// that a newer version of CronCraft has migrated.
+func Migrate() error {
+	list, err := MigrationStatus()
+	if err != nil {
+		return err
+	}
+	if err := CheckSchemaVersion(list); err != nil {
+		return err
+	}
+
+	if _, err := DB.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TEXT NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	for _, m := range list {
+		if !m.Pending() {
+			continue
+		}
+		if err := applyMigration(m); err != nil {
+			return fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+		}
+		log.Printf("Applied migration %04d_%s", m.Version, m.Name)
+	}
+	return nil
+}
+
+// CheckSchemaVersion returns ErrSchemaTooNew when the database has
+// migrations applied that this version does not know
+func CheckSchemaVersion(list []Migration) error {
+	latest := 0
+	for _, m := range list {
+		if m.Known {
+			latest = max(latest, m.Version)
+		}
+	}
+	for _, m := range list {
+		if !m.Known {
+			return fmt.Errorf("%w: it has migration %d applied, this version knows up to %d",
+				ErrSchemaTooNew, m.Version, latest)
+		}
+	}
+	return nil
+}
+
+func applyMigration(m Migration) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(m.sql); err != nil {
+		return err
+	}
+	if m.Version == 1 {
+		if err := addLegacyColumns(tx); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+		m.Version, m.Name, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// appliedMigrations returns the migrations recorded in schema_migrations,
+// by version. It returns none for databases that predate migrations.
+func appliedMigrations() (map[int]Migration, error) {
+	var exists int
+	if err := DB.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'").
+		Scan(&exists); err != nil {
+		return nil, err
+	}
+	applied := make(map[int]Migration)
+	if exists == 0 {
+		return applied, nil
+	}
+
+	rows, err := DB.Query("SELECT version, name, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var m Migration
+		var appliedAt string
+		if err := rows.Scan(&m.Version, &m.Name, &appliedAt); err != nil {
+			return nil, err
+		}
+		m.AppliedAt, _ = time.Parse(time.RFC3339, appliedAt)
+		applied[m.Version] = m
+	}
+	return applied, rows.Err()
+}
+
+// addLegacyColumns brings tables of databases created before migrations
+// existed up to the initial schema. Such databases already have some of the
+// tables, which the initial migration leaves alone, but may lack columns
+// added to them since.
+func addLegacyColumns(tx *sql.Tx) error {
+	columns := []struct{ table, name, definition string }{
+		{"jobs", "timeout_seconds", "INTEGER NOT NULL DEFAULT 0"},
+		{"job_runs", "cancelled_by", "TEXT"},
+		{"jobs", "concurrency_policy", "TEXT NOT NULL DEFAULT 'allow'"},
+		{"jobs", "max_attempts", "INTEGER NOT NULL DEFAULT 1"},
+		{"jobs", "retry_backoff", "TEXT NOT NULL DEFAULT 'fixed'"},
+		{"jobs", "retry_delay_seconds", "INTEGER NOT NULL DEFAULT 30"},
+		{"jobs", "retry_exit_codes", "TEXT NOT NULL DEFAULT ''"},
+		{"job_runs", "attempt", "INTEGER NOT NULL DEFAULT 1"},
+		{"job_runs", "parent_run_id", "INTEGER"},
+		{"job_runs", "exit_code", "INTEGER"},
+		{"job_runs", "signal", "TEXT"},
+		{"jobs", "rerun_interrupted", "INTEGER NOT NULL DEFAULT 0"},
+		{"jobs", "misfire_policy", "TEXT NOT NULL DEFAULT 'ignore'"},
+		{"jobs", "misfire_max", "INTEGER NOT NULL DEFAULT 10"},
+		{"job_runs", "trigger", "TEXT NOT NULL DEFAULT 'schedule'"},
+		{"jobs", "timezone", "TEXT NOT NULL DEFAULT ''"},
+		{"users", "role", "TEXT NOT NULL DEFAULT 'viewer'"},
+		{"jobs", "version", "INTEGER NOT NULL DEFAULT 1"},
+		{"job_runs", "job_version", "INTEGER"},
+	}
+
+	for _, c := range columns {
+		if err := ensureColumn(tx, c.table, c.name, c.definition); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", c.table, c.name, err)
+		}
+	}
+	return nil
+}
+
+// ensureColumn adds a column to an existing table if it is not there yet
+func ensureColumn(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			cid       int
+			name      string
+			colType   string
+			notNull   int
+			dfltValue sql.NullString
+			pk        int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	return err
+}
@@ -0,0 +1,134 @@
+-- Schema of the tables as they were when migrations were introduced. Tables
+-- of databases created before then are left as they are, and their missing
+-- columns are added by addLegacyColumns.
+
+CREATE TABLE IF NOT EXISTS jobs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    schedule TEXT NOT NULL,
+    command TEXT NOT NULL,
+    status TEXT NOT NULL,
+    timeout_seconds INTEGER NOT NULL DEFAULT 0,
+    concurrency_policy TEXT NOT NULL DEFAULT 'allow',
+    max_attempts INTEGER NOT NULL DEFAULT 1,
+    retry_backoff TEXT NOT NULL DEFAULT 'fixed',
+    retry_delay_seconds INTEGER NOT NULL DEFAULT 30,
+    retry_exit_codes TEXT NOT NULL DEFAULT '',
+    rerun_interrupted INTEGER NOT NULL DEFAULT 0,
+    misfire_policy TEXT NOT NULL DEFAULT 'ignore',
+    misfire_max INTEGER NOT NULL DEFAULT 10,
+    timezone TEXT NOT NULL DEFAULT '',
+    version INTEGER NOT NULL DEFAULT 1,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER IF NOT EXISTS trg_jobs_updated_at
+AFTER UPDATE ON jobs
+FOR EACH ROW
+BEGIN
+    UPDATE jobs
+    SET updated_at = CURRENT_TIMESTAMP
+    WHERE id = OLD.id;
+END;
+
+CREATE TABLE IF NOT EXISTS job_runs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    job_id INTEGER NOT NULL,
+    run_at TEXT NOT NULL,
+    status TEXT NOT NULL,
+    output TEXT,
+    duration_ms INT,
+    cancelled_by TEXT,
+    attempt INTEGER NOT NULL DEFAULT 1,
+    parent_run_id INTEGER,
+    exit_code INTEGER,
+    signal TEXT,
+    trigger TEXT NOT NULL DEFAULT 'schedule',
+    job_version INTEGER,
+    FOREIGN KEY(job_id) REFERENCES jobs(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_job_runs_job_id ON job_runs(job_id);
+
+CREATE INDEX IF NOT EXISTS idx_job_runs_run_at ON job_runs(run_at DESC);
+
+CREATE TABLE IF NOT EXISTS job_versions (
+    job_id INTEGER NOT NULL,
+    version INTEGER NOT NULL,
+    definition TEXT NOT NULL,
+    replaced_at TEXT NOT NULL,
+    PRIMARY KEY(job_id, version),
+    FOREIGN KEY(job_id) REFERENCES jobs(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    username TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL,
+    role TEXT NOT NULL DEFAULT 'viewer',
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+    token_hash TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    created_at TEXT NOT NULL,
+    expires_at TEXT NOT NULL,
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS job_grants (
+    user_id INTEGER NOT NULL,
+    job_id INTEGER NOT NULL,
+    role TEXT NOT NULL,
+    PRIMARY KEY(user_id, job_id),
+    FOREIGN KEY(user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY(job_id) REFERENCES jobs(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    scope TEXT NOT NULL,
+    job_scoped INTEGER NOT NULL DEFAULT 0,
+    created_by TEXT NOT NULL,
+    created_at TEXT NOT NULL,
+    expires_at TEXT,
+    last_used_at TEXT
+);
+
+CREATE TABLE IF NOT EXISTS audit_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    created_at TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    ip TEXT NOT NULL,
+    action TEXT NOT NULL,
+    job_id INTEGER NOT NULL,
+    job_name TEXT NOT NULL,
+    run_id INTEGER,
+    changes TEXT NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_job_id ON audit_events(job_id);
+
+CREATE TRIGGER IF NOT EXISTS trg_audit_events_no_update
+    BEFORE UPDATE ON audit_events
+    BEGIN
+        SELECT RAISE(ABORT, 'audit_events is append-only');
+    END;
+
+CREATE TRIGGER IF NOT EXISTS trg_audit_events_no_delete
+    BEFORE DELETE ON audit_events
+    BEGIN
+        SELECT RAISE(ABORT, 'audit_events is append-only');
+    END;
+
+CREATE TABLE IF NOT EXISTS api_token_jobs (
+    token_id INTEGER NOT NULL,
+    job_id INTEGER NOT NULL,
+    PRIMARY KEY(token_id, job_id),
+    FOREIGN KEY(token_id) REFERENCES api_tokens(id) ON DELETE CASCADE,
+    FOREIGN KEY(job_id) REFERENCES jobs(id) ON DELETE CASCADE
+);
@@ -0,0 +1,9 @@
+-- Per-job retention of finished runs. 0 uses the server-wide setting.
+
+ALTER TABLE jobs ADD COLUMN keep_runs INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE jobs ADD COLUMN keep_days INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE jobs ADD COLUMN keep_failed_days INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE jobs ADD COLUMN max_log_mb INTEGER NOT NULL DEFAULT 0;
+
+-- The retention sweeper walks each job's runs newest first
+CREATE INDEX IF NOT EXISTS idx_job_runs_job_id_run_at ON job_runs(job_id, run_at DESC);
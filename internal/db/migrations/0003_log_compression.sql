@@ -0,0 +1,6 @@
+-- Compression of the full logs of old runs. log_archived is set once a
+-- run's log has been handled, and log_bytes holds the uncompressed size of
+-- a compressed log.
+
+ALTER TABLE job_runs ADD COLUMN log_archived INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE job_runs ADD COLUMN log_bytes INTEGER;
@@ -0,0 +1,36 @@
+-- Full-text search over the output of runs. output_lines holds the indexed
+-- lines, numbered as the output viewer numbers them, and output_search is
+-- the FTS5 index over their text, kept in step by the triggers below.
+-- output_indexed is set once a finished run's lines have been added.
+
+ALTER TABLE job_runs ADD COLUMN output_indexed INTEGER NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS idx_job_runs_unindexed ON job_runs(id) WHERE output_indexed = 0;
+
+CREATE TABLE IF NOT EXISTS output_lines (
+    id INTEGER PRIMARY KEY,
+    run_id INTEGER NOT NULL,
+    line INTEGER NOT NULL,
+    text TEXT NOT NULL,
+    FOREIGN KEY(run_id) REFERENCES job_runs(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_output_lines_run_id ON output_lines(run_id);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS output_search USING fts5(
+    text,
+    content = 'output_lines',
+    content_rowid = 'id'
+);
+
+CREATE TRIGGER IF NOT EXISTS trg_output_lines_insert
+AFTER INSERT ON output_lines
+BEGIN
+    INSERT INTO output_search(rowid, text) VALUES (NEW.id, NEW.text);
+END;
+
+CREATE TRIGGER IF NOT EXISTS trg_output_lines_delete
+AFTER DELETE ON output_lines
+BEGIN
+    INSERT INTO output_search(output_search, rowid, text) VALUES ('delete', OLD.id, OLD.text);
+END;
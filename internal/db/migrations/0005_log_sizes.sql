@@ -0,0 +1,13 @@
+-- Sizes of the full logs of runs, recorded when a log is written or
+-- compressed so that listing runs does not look every log up in the log
+-- store. log_bytes now holds the uncompressed size of every log, not only of
+-- compressed ones, log_stored_bytes its size as stored and log_compressed
+-- whether it is gzipped. Sizes are NULL until known; the logs of runs that
+-- finished before this migration are measured once by the log sweeper.
+
+ALTER TABLE job_runs ADD COLUMN log_stored_bytes INTEGER;
+ALTER TABLE job_runs ADD COLUMN log_compressed INTEGER NOT NULL DEFAULT 0;
+
+UPDATE job_runs SET log_compressed = 1 WHERE log_bytes IS NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_job_runs_unmeasured ON job_runs(id) WHERE log_stored_bytes IS NULL;
@@ -0,0 +1,8 @@
+-- Accounts limited to their granted jobs. Until now an account was limited
+-- as long as it had any grant, so removing its last grant, or deleting the
+-- last job it was granted, gave it every job. The flag keeps it limited
+-- until an admin allows it every job again.
+
+ALTER TABLE users ADD COLUMN job_scoped INTEGER NOT NULL DEFAULT 0;
+
+UPDATE users SET job_scoped = 1 WHERE id IN (SELECT user_id FROM job_grants);
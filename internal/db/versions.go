@@ -0,0 +1,87 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/abhilashreddysh/croncraft/internal/models"
+)
+
+// JobDefinition returns the JSON of a job's definition. Whether the job is
+// enabled, its ID, version and run times are not part of it.
+func JobDefinition(j models.Job) ([]byte, error) {
+	j.ID = 0
+	j.Status = false
+	j.Version = 0
+	j.LastRunAt = time.Time{}
+	j.NextRuns = nil
+	return json.Marshal(j)
+}
+
+// SameDefinition reports whether two jobs have the same definition
+func SameDefinition(a, b models.Job) bool {
+	defA, errA := JobDefinition(a)
+	defB, errB := JobDefinition(b)
+	return errA == nil && errB == nil && bytes.Equal(defA, defB)
+}
+
+// SaveJobVersion stores the definition of the job under its current
+// version, before a change replaces it
+func SaveJobVersion(tx *sql.Tx, j models.Job) error {
+	def, err := JobDefinition(j)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		"INSERT OR REPLACE INTO job_versions (job_id, version, definition, replaced_at) VALUES (?, ?, ?, ?)",
+		j.ID, j.Version, string(def), time.Now().UTC().Format(time.RFC3339),
+	)
+	return err
+}
+
+// GetJobVersions returns the earlier versions of a job, newest first
+func GetJobVersions(jobID int) ([]models.JobVersion, error) {
+	rows, err := DB.Query(`
+		SELECT version, definition, replaced_at FROM job_versions
+		WHERE job_id = ? ORDER BY version DESC`, jobID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []models.JobVersion
+	for rows.Next() {
+		v, err := scanJobVersion(rows, jobID)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, *v)
+	}
+	return versions, rows.Err()
+}
+
+// GetJobVersion returns one earlier version of a job, or sql.ErrNoRows if
+// there is no such version
+func GetJobVersion(jobID, version int) (*models.JobVersion, error) {
+	row := DB.QueryRow(`
+		SELECT version, definition, replaced_at FROM job_versions
+		WHERE job_id = ? AND version = ?`, jobID, version)
+	return scanJobVersion(row, jobID)
+}
+
+func scanJobVersion(row interface{ Scan(...any) error }, jobID int) (*models.JobVersion, error) {
+	var v models.JobVersion
+	var def, replacedAt string
+	if err := row.Scan(&v.Version, &def, &replacedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(def), &v.Job); err != nil {
+		return nil, err
+	}
+	v.Job.ID = jobID
+	v.Job.Version = v.Version
+	v.ReplacedAt, _ = time.Parse(time.RFC3339, replacedAt)
+	return &v, nil
+}
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abhilashreddysh/croncraft/internal/utils"
+)
+
+const (
+	defaultPreviewCount = 5
+	maxPreviewCount     = 50
+)
+
+// previewTime is one upcoming fire time of a schedule preview
+type previewTime struct {
+	At      time.Time `json:"at"`
+	Display string    `json:"display"` // formatted in the schedule's zone
+}
+
+// GET /api/schedule/preview?expr=...&tz=...&count=...
+func schedulePreviewHandler(w http.ResponseWriter, r *http.Request) {
+	expr := strings.TrimSpace(r.URL.Query().Get("expr"))
+	tz := strings.TrimSpace(r.URL.Query().Get("tz"))
+	if expr == "" {
+		writeJSONError(w, http.StatusBadRequest, "expr is required")
+		return
+	}
+
+	count := defaultPreviewCount
+	if v := r.URL.Query().Get("count"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPreviewCount {
+			writeJSONError(w, http.StatusBadRequest, "count must be between 1 and "+strconv.Itoa(maxPreviewCount))
+			return
+		}
+		count = n
+	}
+
+	schedule, err := utils.ValidateSchedule(expr, tz)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	loc := utils.LoadLocation(tz)
+	next := []previewTime{}
+	for _, t := range utils.NextRuns(schedule, time.Now(), count) {
+		t = t.In(loc)
+		next = append(next, previewTime{At: t, Display: t.Format("Mon Jan 2, 2006 3:04 PM MST")})
+	}
+
+	zone := tz
+	if zone == "" {
+		zone = serverZone()
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"expr":     expr,
+		"timezone": zone,
+		"next":     next,
+	})
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write JSON response: %v", err)
+	}
+}
+
+// apiError is the body of every JSON error response
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// writeJSONError writes an error message as a JSON response
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, apiError{Error: message})
+}
@@ -0,0 +1,553 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abhilashreddysh/croncraft/internal/auth"
+	"github.com/abhilashreddysh/croncraft/internal/db"
+	"github.com/abhilashreddysh/croncraft/internal/jobs"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+	"github.com/abhilashreddysh/croncraft/internal/utils"
+)
+
+const (
+	defaultRunsPerPage = 50
+	maxRunsPerPage     = 200
+	maxJSONBody        = 1 << 20
+)
+
+// apiRoute is one endpoint of the JSON API. The same definitions register
+// the handlers and generate the OpenAPI document, so the two cannot drift.
+type apiRoute struct {
+	Method      string
+	Path        string // {id} segments are integer path parameters
+	OperationID string
+	Summary     string
+	Handler     http.HandlerFunc
+	Query       []apiParam // optional query parameters
+	Body        any        // request body, nil for none
+	Status      int        // status of a successful response
+	Response    any        // successful response body, nil for none
+}
+
+// apiParam is an optional query parameter of an endpoint
+type apiParam struct {
+	Name        string
+	Type        string // integer or string
+	Format      string
+	Description string
+}
+
+// Bodies of the JSON API responses
+type (
+	jobList struct {
+		Jobs []models.Job `json:"jobs"`
+	}
+
+	runPage struct {
+		Runs    []models.Run `json:"runs"`
+		Page    int          `json:"page"`
+		PerPage int          `json:"per_page"`
+		Total   int          `json:"total"` // runs matching the filters on all pages
+	}
+
+	runStatus struct {
+		RunID  int64  `json:"run_id"`
+		Status string `json:"status"`
+	}
+
+	runOutput struct {
+		RunID int          `json:"run_id"`
+		Lines []outputLine `json:"lines"`
+	}
+
+	// outputLine is one line of a run's output
+	outputLine struct {
+		Line   int    `json:"line"`
+		Stream string `json:"stream"` // stdout or stderr
+		Text   string `json:"text"`
+	}
+)
+
+var runFilters = []apiParam{
+	{"status", "string", "", "Only runs with this status"},
+	{"trigger", "string", "", "Only runs started by this trigger"},
+	{"since", "string", "date-time", "Only runs started at or after this time"},
+	{"until", "string", "date-time", "Only runs started before this time"},
+	{"page", "integer", "", "Page to return, starting at 1"},
+	{"per_page", "integer", "", "Runs per page, at most 200"},
+}
+
+// apiV1Routes lists every /api/v1 endpoint
+var apiV1Routes = []apiRoute{
+	{Method: "GET", Path: "/api/v1/jobs", OperationID: "listJobs", Summary: "List jobs",
+		Handler: apiListJobs, Status: http.StatusOK, Response: jobList{}},
+	{Method: "POST", Path: "/api/v1/jobs", OperationID: "createJob", Summary: "Create a job",
+		Handler: apiCreateJob, Body: models.Job{}, Status: http.StatusCreated, Response: models.Job{}},
+	{Method: "GET", Path: "/api/v1/jobs/{id}", OperationID: "getJob", Summary: "Get a job",
+		Handler: apiGetJob, Status: http.StatusOK, Response: models.Job{}},
+	{Method: "PUT", Path: "/api/v1/jobs/{id}", OperationID: "updateJob", Summary: "Update a job; fields left out keep their values",
+		Handler: apiUpdateJob, Body: models.Job{}, Status: http.StatusOK, Response: models.Job{}},
+	{Method: "DELETE", Path: "/api/v1/jobs/{id}", OperationID: "deleteJob", Summary: "Delete a job and its runs",
+		Handler: apiDeleteJob, Status: http.StatusNoContent},
+	{Method: "POST", Path: "/api/v1/jobs/{id}/enable", OperationID: "enableJob", Summary: "Enable a job",
+		Handler: apiSetJobEnabled(true), Status: http.StatusOK, Response: models.Job{}},
+	{Method: "POST", Path: "/api/v1/jobs/{id}/disable", OperationID: "disableJob", Summary: "Disable a job",
+		Handler: apiSetJobEnabled(false), Status: http.StatusOK, Response: models.Job{}},
+	{Method: "POST", Path: "/api/v1/jobs/{id}/runs", OperationID: "triggerRun", Summary: "Trigger a run of a job",
+		Handler: apiTriggerRun, Status: http.StatusAccepted, Response: runStatus{}},
+	{Method: "GET", Path: "/api/v1/jobs/{id}/runs", OperationID: "listJobRuns", Summary: "List runs of a job, newest first",
+		Handler: apiListJobRuns, Query: runFilters, Status: http.StatusOK, Response: runPage{}},
+	{Method: "GET", Path: "/api/v1/runs", OperationID: "listRuns", Summary: "List runs, newest first",
+		Handler: apiListRuns, Query: append([]apiParam{{"job_id", "integer", "", "Only runs of this job"}}, runFilters...),
+		Status: http.StatusOK, Response: runPage{}},
+	{Method: "GET", Path: "/api/v1/runs/{id}", OperationID: "getRun", Summary: "Get a run",
+		Handler: apiGetRun, Status: http.StatusOK, Response: models.Run{}},
+	{Method: "GET", Path: "/api/v1/runs/{id}/output", OperationID: "getRunOutput", Summary: "Get the output lines of a run",
+		Handler: apiGetRunOutput, Query: []apiParam{{"stream", "string", "", "Only lines of this stream, stdout or stderr"}},
+		Status: http.StatusOK, Response: runOutput{}},
+	{Method: "POST", Path: "/api/v1/runs/{id}/cancel", OperationID: "cancelRun", Summary: "Cancel a running or queued run",
+		Handler: apiCancelRun, Status: http.StatusOK, Response: runStatus{}},
+	{Method: "GET", Path: "/api/v1/audit", OperationID: "listAuditEvents", Summary: "List audit events, newest first",
+		Handler: apiListAudit, Query: auditFilters, Status: http.StatusOK, Response: auditPage{}},
+	{Method: "GET", Path: "/api/v1/search", OperationID: "searchOutput", Summary: "Search the output of runs, newest run first",
+		Handler: apiSearchOutput, Query: searchParams, Status: http.StatusOK, Response: searchPage{}},
+}
+
+// setupAPIv1 registers the /api/v1 endpoints, their OpenAPI document and
+// the API explorer
+func setupAPIv1() {
+	for _, route := range apiV1Routes {
+		http.HandleFunc(route.Method+" "+route.Path, route.Handler)
+	}
+	http.HandleFunc("GET /api/v1/openapi.json", openAPIHandler)
+	http.HandleFunc("GET /api/docs", apiDocsHandler)
+	http.HandleFunc("/api/v1/", func(w http.ResponseWriter, r *http.Request) {
+		writeJSONError(w, http.StatusNotFound, "no such endpoint")
+	})
+}
+
+// GET /api/v1/jobs
+func apiListJobs(w http.ResponseWriter, r *http.Request) {
+	if !apiAuthorize(w, r, models.RoleViewer, 0) {
+		return
+	}
+
+	list, err := db.GetJobsFromDB()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	list = visibleJobs(r, list)
+	if list == nil {
+		list = []models.Job{}
+	}
+	writeJSON(w, http.StatusOK, jobList{Jobs: list})
+}
+
+// POST /api/v1/jobs
+func apiCreateJob(w http.ResponseWriter, r *http.Request) {
+	if !apiAuthorize(w, r, models.RoleAdmin, 0) {
+		return
+	}
+
+	job := utils.NewJob()
+	if !decodeJob(w, r, job) {
+		return
+	}
+	job.ID = 0
+
+	if err := saveJob(r, job); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to add job: "+err.Error())
+		return
+	}
+	writeJobResponse(w, http.StatusCreated, job.ID)
+}
+
+// GET /api/v1/jobs/{id}
+func apiGetJob(w http.ResponseWriter, r *http.Request) {
+	j, ok := apiLoadJob(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+// PUT /api/v1/jobs/{id}. Fields left out of the body keep their values.
+func apiUpdateJob(w http.ResponseWriter, r *http.Request) {
+	j, ok := apiLoadJob(w, r, models.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	id := j.ID
+	if !decodeJob(w, r, j) {
+		return
+	}
+	j.ID = id
+
+	if err := saveJob(r, j); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to update job: "+err.Error())
+		return
+	}
+	writeJobResponse(w, http.StatusOK, j.ID)
+}
+
+// DELETE /api/v1/jobs/{id}
+func apiDeleteJob(w http.ResponseWriter, r *http.Request) {
+	j, ok := apiLoadJob(w, r, models.RoleAdmin)
+	if !ok {
+		return
+	}
+
+	if err := removeJob(r, j.ID); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to delete job: "+err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /api/v1/jobs/{id}/enable and /disable
+func apiSetJobEnabled(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		j, ok := apiLoadJob(w, r, models.RoleAdmin)
+		if !ok {
+			return
+		}
+
+		j.Status = enabled
+		if err := saveJob(r, j); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, "failed to update job: "+err.Error())
+			return
+		}
+		writeJobResponse(w, http.StatusOK, j.ID)
+	}
+}
+
+// POST /api/v1/jobs/{id}/runs
+func apiTriggerRun(w http.ResponseWriter, r *http.Request) {
+	j, ok := apiLoadJob(w, r, models.RoleOperator)
+	if !ok {
+		return
+	}
+
+	runID, status, err := jobs.Dispatch(*j, models.TriggerManual)
+	if errors.Is(err, jobs.ErrShuttingDown) {
+		writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to start job: "+err.Error())
+		return
+	}
+	recordRunAudit(r, models.AuditManualRun, j.ID, runID)
+
+	writeJSON(w, http.StatusAccepted, runStatus{RunID: runID, Status: status})
+}
+
+// GET /api/v1/jobs/{id}/runs
+func apiListJobRuns(w http.ResponseWriter, r *http.Request) {
+	j, ok := apiLoadJob(w, r, models.RoleViewer)
+	if !ok {
+		return
+	}
+	listRuns(w, r, j.ID)
+}
+
+// GET /api/v1/runs
+func apiListRuns(w http.ResponseWriter, r *http.Request) {
+	if !apiAuthorize(w, r, models.RoleViewer, 0) {
+		return
+	}
+
+	jobID := 0
+	if v := r.URL.Query().Get("job_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid job_id")
+			return
+		}
+		if !apiAuthorize(w, r, models.RoleViewer, id) {
+			return
+		}
+		jobID = id
+	}
+	listRuns(w, r, jobID)
+}
+
+// listRuns writes a page of runs matching the query's filters, limited to
+// one job unless jobID is 0
+func listRuns(w http.ResponseWriter, r *http.Request, jobID int) {
+	q := r.URL.Query()
+
+	var where []string
+	var args []any
+	if jobID != 0 {
+		where = append(where, "r.job_id = ?")
+		args = append(args, jobID)
+	} else if ids := auth.UserFromContext(r.Context()).GrantedJobs(); ids != nil {
+		where = append(where, "r.job_id IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")+")")
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	for _, field := range []string{"status", "trigger"} {
+		if v := q.Get(field); v != "" {
+			where = append(where, "r."+field+" = ?")
+			args = append(args, v)
+		}
+	}
+	for _, f := range []struct{ param, op string }{{"since", ">="}, {"until", "<"}} {
+		v := q.Get(f.param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, f.param+" must be an RFC 3339 time")
+			return
+		}
+		where = append(where, "datetime(r.run_at) "+f.op+" datetime(?)")
+		args = append(args, t.UTC().Format(time.RFC3339))
+	}
+
+	page, err := queryInt(r, "page", 1, 1, 0)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	perPage, err := queryInt(r, "per_page", defaultRunsPerPage, 1, maxRunsPerPage)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	filter := ""
+	if len(where) > 0 {
+		filter = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	var total int
+	if err := db.DB.QueryRow("SELECT COUNT(*) FROM job_runs r"+filter, args...).Scan(&total); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	runs, err := queryRuns(filter+" ORDER BY r.id DESC LIMIT ? OFFSET ?",
+		append(args, perPage, (page-1)*perPage)...)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, runPage{Runs: runs, Page: page, PerPage: perPage, Total: total})
+}
+
+// GET /api/v1/runs/{id}
+func apiGetRun(w http.ResponseWriter, r *http.Request) {
+	runID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid run ID")
+		return
+	}
+
+	runs, err := queryRuns(" WHERE r.id = ?", runID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if len(runs) == 0 || !auth.UserFromContext(r.Context()).CanSee(runs[0].JobID) {
+		writeJSONError(w, http.StatusNotFound, "run not found")
+		return
+	}
+	if !apiAuthorize(w, r, models.RoleViewer, runs[0].JobID) {
+		return
+	}
+	writeJSON(w, http.StatusOK, runs[0])
+}
+
+// GET /api/v1/runs/{id}/output?stream=stdout|stderr
+func apiGetRunOutput(w http.ResponseWriter, r *http.Request) {
+	runID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid run ID")
+		return
+	}
+
+	stream := r.URL.Query().Get("stream")
+	if stream != "" && stream != jobs.StreamStdout && stream != jobs.StreamStderr {
+		writeJSONError(w, http.StatusBadRequest, "invalid stream, use stdout or stderr")
+		return
+	}
+
+	_, j, preview, err := getRunWithOutput(runID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !auth.UserFromContext(r.Context()).CanSee(j.ID)) {
+		writeJSONError(w, http.StatusNotFound, "run not found")
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if !apiAuthorize(w, r, models.RoleViewer, j.ID) {
+		return
+	}
+
+	lines := []outputLine{}
+	_, err = forEachOutputLine(runID, preview, func(n int, lineStream, text string) error {
+		if stream == "" || lineStream == stream {
+			lines = append(lines, outputLine{Line: n, Stream: lineStream, Text: text})
+		}
+		return nil
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to read output: "+err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, runOutput{RunID: runID, Lines: lines})
+}
+
+// POST /api/v1/runs/{id}/cancel
+func apiCancelRun(w http.ResponseWriter, r *http.Request) {
+	runID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid run ID")
+		return
+	}
+
+	jobID, err := runJobID(runID)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !auth.UserFromContext(r.Context()).CanSee(jobID)) {
+		writeJSONError(w, http.StatusNotFound, "run not found")
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	if !apiAuthorize(w, r, models.RoleOperator, jobID) {
+		return
+	}
+
+	if err := jobs.CancelRun(runID, actor(r)); errors.Is(err, jobs.ErrRunNotActive) {
+		writeJSONError(w, http.StatusConflict, "run is not running")
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "failed to cancel run: "+err.Error())
+		return
+	}
+	recordRunAudit(r, models.AuditCancel, jobID, runID)
+
+	writeJSON(w, http.StatusOK, runStatus{RunID: runID, Status: models.StatusCancelled})
+}
+
+// apiLoadJob loads the job named by the {id} path value after checking
+// that the account has at least role on it, writing the error response when
+// it cannot
+func apiLoadJob(w http.ResponseWriter, r *http.Request, role string) (*models.Job, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid job ID")
+		return nil, false
+	}
+	if !apiAuthorize(w, r, role, id) {
+		return nil, false
+	}
+
+	j, err := db.GetJob(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeJSONError(w, http.StatusNotFound, "job not found")
+		return nil, false
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return nil, false
+	}
+	return j, true
+}
+
+// decodeJob reads a job definition from the JSON body over job and
+// validates it like the job forms do
+func decodeJob(w http.ResponseWriter, r *http.Request, job *models.Job) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJSONBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(job); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return false
+	}
+
+	if err := utils.ValidateJob(job); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return false
+	}
+	return true
+}
+
+// writeJobResponse reloads a job after a change and writes it
+func writeJobResponse(w http.ResponseWriter, status, id int) {
+	j, err := db.GetJob(id)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	writeJSON(w, status, j)
+}
+
+// queryRuns loads the runs selected by the WHERE, ORDER and LIMIT clauses in
+// rest
+func queryRuns(rest string, args ...any) ([]models.Run, error) {
+	rows, err := db.DB.Query(`
+		SELECT r.id, r.job_id, r.run_at, r.status, r.duration_ms, COALESCE(LENGTH(r.output), 0),
+		       COALESCE(r.cancelled_by, ''), r.attempt, COALESCE(r.parent_run_id, 0), r.exit_code,
+		       COALESCE(r.signal, ''), r.trigger, COALESCE(r.job_version, 0),
+		       COALESCE(r.log_bytes, 0), COALESCE(r.log_stored_bytes, 0), r.log_compressed
+		FROM job_runs r`+rest, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs := []models.Run{}
+	for rows.Next() {
+		var run models.Run
+		var runAt string
+		var durationMs, exitCode sql.NullInt64
+		if err := rows.Scan(&run.ID, &run.JobID, &runAt, &run.Status, &durationMs, &run.OutputBytes,
+			&run.CancelledBy, &run.Attempt, &run.ParentID, &exitCode, &run.Signal, &run.Trigger,
+			&run.JobVersion, &run.LogBytes, &run.LogStoredBytes, &run.LogCompressed); err != nil {
+			return nil, err
+		}
+
+		run.RunAt, _ = time.Parse(time.RFC3339, runAt)
+		if durationMs.Valid {
+			run.DurationMs = &durationMs.Int64
+		}
+		if exitCode.Valid {
+			code := int(exitCode.Int64)
+			run.ExitCode = &code
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// queryInt reads an optional whole-number query parameter between min and
+// max, where a max of 0 means no upper bound
+func queryInt(r *http.Request, param string, def, min, max int) (int, error) {
+	v := r.URL.Query().Get(param)
+	if v == "" {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min || (max > 0 && n > max) {
+		if max > 0 {
+			return 0, fmt.Errorf("%s must be between %d and %d", param, min, max)
+		}
+		return 0, fmt.Errorf("%s must be at least %d", param, min)
+	}
+	return n, nil
+}
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/abhilashreddysh/croncraft/internal/audit"
+	"github.com/abhilashreddysh/croncraft/internal/db"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+	"github.com/abhilashreddysh/croncraft/internal/utils"
+)
+
+// auditPage is the body of the audit log API response
+type auditPage struct {
+	Events  []models.AuditEvent `json:"events"`
+	Page    int                 `json:"page"`
+	PerPage int                 `json:"per_page"`
+	Total   int                 `json:"total"` // events matching the filters on all pages
+}
+
+var auditFilters = []apiParam{
+	{"action", "string", "", "Only events of this action"},
+	{"actor", "string", "", "Only events by this account or API token"},
+	{"job_id", "integer", "", "Only events about this job"},
+	{"since", "string", "date-time", "Only events at or after this time"},
+	{"until", "string", "date-time", "Only events before this time"},
+	{"page", "integer", "", "Page to return, starting at 1"},
+	{"per_page", "integer", "", "Events per page, at most 200"},
+}
+
+// saveJob creates or updates a job like UpsertJob and records the change
+// in the audit log
+func saveJob(r *http.Request, job *models.Job) error {
+	var before *models.Job
+	if job.ID != 0 {
+		j, err := db.GetJob(job.ID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		before = j
+	}
+
+	if err := UpsertJob(job); err != nil {
+		return err
+	}
+
+	changes, err := audit.Diff(before, job)
+	if err != nil {
+		log.Printf("Failed to diff job %d for the audit log: %v", job.ID, err)
+	}
+
+	action := models.AuditCreate
+	if before != nil {
+		if len(changes) == 0 {
+			return nil
+		}
+		action = models.AuditUpdate
+		if _, ok := changes["enabled"]; ok && len(changes) == 1 {
+			action = models.AuditDisable
+			if job.Status {
+				action = models.AuditEnable
+			}
+		}
+	}
+
+	recordAudit(r, models.AuditEvent{Action: action, JobID: job.ID, JobName: job.Name, Changes: changes})
+	return nil
+}
+
+// removeJob deletes a job and its logs like DeleteJob and records the
+// deletion in the audit log
+func removeJob(r *http.Request, jobID int) error {
+	before, err := db.GetJob(jobID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DeleteJob(jobID, true)
+	} else if err != nil {
+		return err
+	}
+
+	if err := DeleteJob(jobID, true); err != nil {
+		return err
+	}
+
+	changes, err := audit.Diff(before, nil)
+	if err != nil {
+		log.Printf("Failed to diff job %d for the audit log: %v", jobID, err)
+	}
+	recordAudit(r, models.AuditEvent{Action: models.AuditDelete, JobID: jobID, JobName: before.Name, Changes: changes})
+	return nil
+}
+
+// recordRunAudit records a manual run or cancellation of a job's run
+func recordRunAudit(r *http.Request, action string, jobID int, runID int64) {
+	e := models.AuditEvent{Action: action, JobID: jobID, RunID: runID}
+	if j, err := db.GetJob(jobID); err == nil {
+		e.JobName = j.Name
+	}
+	recordAudit(r, e)
+}
+
+// recordAudit stamps an event with who made the request and from where,
+// and appends it to the audit log
+func recordAudit(r *http.Request, e models.AuditEvent) {
+	e.Actor = actor(r)
+	e.IP = clientIP(r)
+	if err := audit.Record(e); err != nil {
+		log.Printf("Failed to record %s of job %d in the audit log: %v", e.Action, e.JobID, err)
+	}
+}
+
+// GET /audit
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !authorize(w, r, models.RoleAdmin, 0) {
+		return
+	}
+
+	filter, page, perPage, err := parseAuditQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	events, total, err := audit.List(filter, perPage, (page-1)*perPage)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	// Link to the neighbouring pages with the same filters
+	query := r.URL.Query()
+	pageURL := func(p int) string {
+		query.Set("page", strconv.Itoa(p))
+		return "/audit?" + query.Encode()
+	}
+	data := map[string]interface{}{
+		"ActivePage": "audit",
+		"Events":     events,
+		"Actions":    models.AuditActions,
+		"Query":      r.URL.Query(),
+		"Page":       page,
+		"Total":      total,
+	}
+	if page > 1 {
+		data["PrevURL"] = pageURL(page - 1)
+	}
+	if page*perPage < total {
+		data["NextURL"] = pageURL(page + 1)
+	}
+
+	tmpl, err := createTemplate().ParseFS(templatesFS,
+		"templates/base.html",
+		"templates/audit.html",
+	)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Template parse error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.ExecuteTemplate(w, "base", pageData(r, data)); err != nil {
+		log.Printf("Template execution error: %v", err)
+	}
+}
+
+// GET /api/v1/audit
+func apiListAudit(w http.ResponseWriter, r *http.Request) {
+	if !apiAuthorize(w, r, models.RoleAdmin, 0) {
+		return
+	}
+
+	filter, page, perPage, err := parseAuditQuery(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	events, total, err := audit.List(filter, perPage, (page-1)*perPage)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	writeJSON(w, http.StatusOK, auditPage{Events: events, Page: page, PerPage: perPage, Total: total})
+}
+
+// parseAuditQuery reads the audit log filters and page from the query
+func parseAuditQuery(r *http.Request) (audit.Filter, int, int, error) {
+	q := r.URL.Query()
+	f := audit.Filter{Action: q.Get("action"), Actor: q.Get("actor")}
+
+	jobID, err := queryInt(r, "job_id", 0, 0, 0)
+	if err != nil {
+		return f, 0, 0, err
+	}
+	f.JobID = jobID
+
+	f.Since, f.Until, err = parseTimeRange(r)
+	if err != nil {
+		return f, 0, 0, err
+	}
+
+	page, err := queryInt(r, "page", 1, 1, 0)
+	if err != nil {
+		return f, 0, 0, err
+	}
+	perPage, err := queryInt(r, "per_page", defaultRunsPerPage, 1, maxRunsPerPage)
+	if err != nil {
+		return f, 0, 0, err
+	}
+	return f, page, perPage, nil
+}
+
+// parseTimeRange reads the optional since and until query parameters, as
+// RFC 3339 times or dates
+func parseTimeRange(r *http.Request) (time.Time, time.Time, error) {
+	var since, until time.Time
+	for _, t := range []struct {
+		param string
+		dst   *time.Time
+	}{{"since", &since}, {"until", &until}} {
+		v := r.URL.Query().Get(t.param)
+		if v == "" {
+			continue
+		}
+		parsed, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			// Dates, as sent by date inputs, cover the whole day
+			day, dateErr := time.ParseInLocation("2006-01-02", v, utils.ServerLocation)
+			if dateErr != nil {
+				return since, until, fmt.Errorf("%s must be an RFC 3339 time or a date", t.param)
+			}
+			if t.param == "until" {
+				day = day.AddDate(0, 0, 1)
+			}
+			parsed = day
+		}
+		*t.dst = parsed
+	}
+	return since, until, nil
+}
+
+// jsonValue shows a changed field's value as JSON, so that strings, numbers
+// and missing values can be told apart
+func jsonValue(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
@@ -0,0 +1,301 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/abhilashreddysh/croncraft/internal/auth"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+)
+
+// publicPaths can be reached without signing in
+var publicPaths = map[string]bool{
+	"/login":     true,
+	"/style.css": true,
+}
+
+// RequireLogin wraps the application routes so that every request comes
+// from a signed in account or carries an API token. Pages redirect to the
+// login page and the JSON API answers 401.
+func RequireLogin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if publicPaths[r.URL.Path] {
+			// Stop other sites from signing visitors in to an account of theirs
+			if err := crossOrigin.Check(r); err != nil {
+				writeCSRFError(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if token, ok := bearerToken(r); ok {
+			u, err := auth.TokenUser(token)
+			if err != nil {
+				if !errors.Is(err, auth.ErrInvalidToken) {
+					log.Printf("Failed to look up API token: %v", err)
+				}
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				if strings.HasPrefix(r.URL.Path, "/api/") {
+					writeJSONError(w, http.StatusUnauthorized, auth.ErrInvalidToken.Error())
+				} else {
+					http.Error(w, "Invalid or expired API token", http.StatusUnauthorized)
+				}
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(auth.WithUser(r.Context(), u)))
+			return
+		}
+
+		if cookie, err := r.Cookie(auth.SessionCookie); err == nil {
+			u, err := auth.SessionUser(cookie.Value)
+			if err == nil {
+				token := auth.CSRFToken(cookie.Value)
+				if err := checkCSRF(r, token); err != nil {
+					writeCSRFError(w, r, err)
+					return
+				}
+				ctx := withCSRFToken(auth.WithUser(r.Context(), u), token)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			} else if !errors.Is(err, auth.ErrNoSession) {
+				log.Printf("Failed to look up session: %v", err)
+			}
+		}
+
+		if strings.HasPrefix(r.URL.Path, "/api/") {
+			writeJSONError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+	})
+}
+
+// bearerToken returns the API token of an Authorization: Bearer header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+// pageData adds what every page needs, such as the signed in account and
+// the CSRF token its forms send back, to the template data of a page
+func pageData(r *http.Request, data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	data["User"] = auth.UserFromContext(r.Context())
+	data["CSRFToken"] = csrfToken(r)
+	return data
+}
+
+// roleStatus checks that the signed in account has at least role on the
+// job, or on the account itself for a jobID of 0. It returns 0 when the
+// request is allowed, 404 for jobs hidden from the account and 403 when the
+// account's role is too low.
+func roleStatus(r *http.Request, role string, jobID int) int {
+	u := auth.UserFromContext(r.Context())
+	if jobID != 0 && !u.CanSee(jobID) {
+		return http.StatusNotFound
+	}
+	if !u.Can(role, jobID) {
+		return http.StatusForbidden
+	}
+	return 0
+}
+
+// authorize checks the account's role like roleStatus and writes the error
+// page when the request is not allowed
+func authorize(w http.ResponseWriter, r *http.Request, role string, jobID int) bool {
+	switch roleStatus(r, role, jobID) {
+	case http.StatusNotFound:
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return false
+	case http.StatusForbidden:
+		http.Error(w, "Forbidden: "+forbiddenReason(r, role), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// apiAuthorize checks the account's role like roleStatus and writes the
+// JSON error when the request is not allowed
+func apiAuthorize(w http.ResponseWriter, r *http.Request, role string, jobID int) bool {
+	switch roleStatus(r, role, jobID) {
+	case http.StatusNotFound:
+		writeJSONError(w, http.StatusNotFound, "job not found")
+		return false
+	case http.StatusForbidden:
+		writeJSONError(w, http.StatusForbidden, forbiddenReason(r, role))
+		return false
+	}
+	return true
+}
+
+// forbiddenReason explains why a request needing role was refused
+func forbiddenReason(r *http.Request, role string) string {
+	if u := auth.UserFromContext(r.Context()); u != nil && u.Scope != "" {
+		return "the " + u.Scope + " scope of this API token does not allow this"
+	}
+	return "this requires the " + role + " role"
+}
+
+// visibleJobs drops the jobs hidden from the signed in account
+func visibleJobs(r *http.Request, list []models.Job) []models.Job {
+	u := auth.UserFromContext(r.Context())
+	visible := list[:0]
+	for _, j := range list {
+		if u.CanSee(j.ID) {
+			visible = append(visible, j)
+		}
+	}
+	return visible
+}
+
+// actor names who made a request, for recording who did what
+func actor(r *http.Request) string {
+	if u := auth.UserFromContext(r.Context()); u != nil {
+		return u.Username
+	}
+	return clientIP(r)
+}
+
+// localRedirect returns next when it is a path on this server to go back to
+// after signing in, or "/" otherwise. Browsers read backslashes as slashes
+// and drop control characters, so those are refused rather than let a path
+// like /\evil.com lead to another site.
+func localRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.ContainsFunc(next, func(c rune) bool {
+		return c == '\\' || unicode.IsControl(c)
+	}) {
+		return "/"
+	}
+	u, err := url.Parse(next)
+	if err != nil || u.Scheme != "" || u.Host != "" || strings.HasPrefix(next, "//") {
+		return "/"
+	}
+	return next
+}
+
+// GET and POST /login
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	next := localRedirect(r.FormValue("next"))
+
+	switch r.Method {
+	case http.MethodGet:
+		renderLogin(w, r, next, "")
+
+	case http.MethodPost:
+		u, err := auth.Authenticate(r.FormValue("username"), r.FormValue("password"))
+		if errors.Is(err, auth.ErrInvalidCredentials) {
+			log.Printf("Failed login for %q from %s", r.FormValue("username"), clientIP(r))
+			w.WriteHeader(http.StatusUnauthorized)
+			renderLogin(w, r, next, err.Error())
+			return
+		} else if err != nil {
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+
+		token, expires, err := auth.CreateSession(u.ID)
+		if err != nil {
+			http.Error(w, "Failed to start session", http.StatusInternalServerError)
+			return
+		}
+		setSessionCookie(w, r, token, expires)
+		http.Redirect(w, r, next, http.StatusSeeOther)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func renderLogin(w http.ResponseWriter, r *http.Request, next, message string) {
+	tmpl := template.Must(template.ParseFS(templatesFS,
+		"templates/base.html",
+		"templates/login.html",
+	))
+	if err := tmpl.ExecuteTemplate(w, "base", pageData(r, map[string]interface{}{
+		"Next":  next,
+		"Error": message,
+	})); err != nil {
+		log.Printf("Template execution error: %v", err)
+	}
+}
+
+// POST /logout
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(auth.SessionCookie); err == nil {
+		if err := auth.DeleteSession(cookie.Value); err != nil {
+			log.Printf("Failed to end session: %v", err)
+		}
+	}
+	setSessionCookie(w, r, "", time.Unix(0, 0))
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// GET and POST /account
+func accountHandler(w http.ResponseWriter, r *http.Request) {
+	u := auth.UserFromContext(r.Context())
+	data := map[string]interface{}{"ActivePage": "account"}
+
+	switch r.Method {
+	case http.MethodGet:
+
+	case http.MethodPost:
+		var err error
+		if r.FormValue("password") != r.FormValue("confirm") {
+			err = errors.New("new passwords do not match")
+		} else {
+			err = auth.ChangePassword(u, r.FormValue("current"), r.FormValue("password"))
+		}
+
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			data["Error"] = err.Error()
+			break
+		}
+
+		if cookie, err := r.Cookie(auth.SessionCookie); err == nil {
+			_ = auth.DeleteOtherSessions(u.ID, cookie.Value)
+		}
+		data["Message"] = "Password changed. Other sessions were signed out."
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	tmpl := template.Must(template.ParseFS(templatesFS,
+		"templates/base.html",
+		"templates/account.html",
+	))
+	if err := tmpl.ExecuteTemplate(w, "base", pageData(r, data)); err != nil {
+		log.Printf("Template execution error: %v", err)
+	}
+}
+
+// setSessionCookie sets the session cookie, or clears it for an empty token
+func setSessionCookie(w http.ResponseWriter, r *http.Request, token string, expires time.Time) {
+	cookie := &http.Cookie{
+		Name:     auth.SessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+	if token == "" {
+		cookie.MaxAge = -1
+	}
+	http.SetCookie(w, cookie)
+}
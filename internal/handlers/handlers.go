@@ -5,15 +5,16 @@ import (
 	"embed"
 	"errors"
 	"fmt"
+	"html"
 	"html/template"
 	"io"
 	"log"
 	"net/http"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/abhilashreddysh/croncraft/internal/auth"
 	"github.com/abhilashreddysh/croncraft/internal/db"
 	"github.com/abhilashreddysh/croncraft/internal/jobs"
 	"github.com/abhilashreddysh/croncraft/internal/models"
@@ -27,11 +28,32 @@ func SetupHTTPHandlers() {
 	// Static files	
 	http.HandleFunc("/style.css", serveStaticFile("text/css", "templates/static/style.css"))
 
+	// Accounts
+	http.HandleFunc("/login", loginHandler)
+	http.HandleFunc("POST /logout", logoutHandler)
+	http.HandleFunc("/account", accountHandler)
+	http.HandleFunc("/users", usersHandler)
+	http.HandleFunc("POST /users/{id}/role", userRoleHandler)
+	http.HandleFunc("POST /users/{id}/delete", userDeleteHandler)
+	http.HandleFunc("POST /users/{id}/grants", userGrantHandler)
+	http.HandleFunc("POST /users/{id}/grants/all", userAllJobsHandler)
+	http.HandleFunc("/tokens", tokensHandler)
+	http.HandleFunc("POST /tokens/{id}/revoke", revokeTokenHandler)
+	http.HandleFunc("/audit", auditHandler)
+	http.HandleFunc("/search", searchHandler)
+
 	// Application routes
 	http.HandleFunc("/", overviewHandler)
 	http.HandleFunc("/add", addJobHandler)
 	http.HandleFunc("/run/", runHandler)
 	http.HandleFunc("/delete/", deleteJobHandler)
+	http.HandleFunc("POST /runs/{id}/cancel", cancelRunHandler)
+	http.HandleFunc("POST /edit/{id}/restore/{version}", restoreVersionHandler)
+	http.HandleFunc("GET /logs/{id}/stream", streamHandler)
+	http.HandleFunc("GET /api/schedule/preview", schedulePreviewHandler)
+
+	// JSON API
+	setupAPIv1()
 	http.HandleFunc("/edit/", func(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
@@ -71,14 +93,19 @@ func overviewHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authorize(w, r, models.RoleViewer, 0) {
+		return
+	}
+
 	jobs, err := db.GetJobsFromDB()
 	if err != nil {
 		http.Error(w, fmt.Sprintf("DB error: %v", err), http.StatusInternalServerError)
 		return
 	}
+	jobs = visibleJobs(r, jobs)
 
 	// Parse base and index together
-	tmpl, err := template.ParseFS(templatesFS,
+	tmpl, err := createTemplate().ParseFS(templatesFS,
 		"templates/base.html",
 		"templates/overview.html",
         "templates/modals/delete_confirm.html",
@@ -89,7 +116,7 @@ func overviewHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Execute base template (which includes index.html)
-	if err := tmpl.ExecuteTemplate(w, "base", map[string]interface{}{"Jobs": jobs,"ActivePage": "overview"}); err != nil {
+	if err := tmpl.ExecuteTemplate(w, "base", pageData(r, map[string]interface{}{"Jobs": jobs,"ActivePage": "overview"})); err != nil {
 		http.Error(w, fmt.Sprintf("Template execute error: %v", err), http.StatusInternalServerError)
 		return
 	}
@@ -97,6 +124,10 @@ func overviewHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func addJobHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, models.RoleAdmin, 0) {
+		return
+	}
+
 	switch r.Method {
 	case http.MethodGet:
 		tmpl := template.Must(template.ParseFS(
@@ -105,7 +136,11 @@ func addJobHandler(w http.ResponseWriter, r *http.Request) {
 			"templates/add.html",
 			"templates/modals/schedule_helper.html",
 		))
-		_ = tmpl.ExecuteTemplate(w, "base", map[string]interface{}{"ActivePage": "add"})
+		_ = tmpl.ExecuteTemplate(w, "base", pageData(r, map[string]interface{}{
+			"ActivePage": "add",
+			"ServerZone": serverZone(),
+			"Retention":  jobs.DefaultRetention,
+		}))
 
 	case http.MethodPost:
 		job, err := utils.ParseJobForm(r)
@@ -114,7 +149,7 @@ func addJobHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if err := UpsertJob(job); err != nil {
+		if err := saveJob(r, job); err != nil {
 			http.Error(w, "Failed to add job: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
@@ -139,11 +174,11 @@ func runHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid job ID", http.StatusBadRequest)
 		return
 	}
+	if !authorize(w, r, models.RoleOperator, id) {
+		return
+	}
 
-	var j models.Job
-	err = db.DB.QueryRow("SELECT name, command FROM jobs WHERE id = ?", id).
-		Scan(&j.Name, &j.Command)
-
+	j, err := db.GetJob(id)
 	if errors.Is(err, sql.ErrNoRows) {
 		http.Error(w, "Job not found", http.StatusNotFound)
 		return
@@ -152,8 +187,57 @@ func runHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	go jobs.RunJob(id, j.Name, j.Command)
-	w.Write([]byte("Job started in background"))
+	runID, status, err := jobs.Dispatch(*j, models.TriggerManual)
+	if err != nil {
+		http.Error(w, "Failed to start job: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordRunAudit(r, models.AuditManualRun, j.ID, runID)
+
+	switch status {
+	case models.StatusSkipped:
+		w.Write([]byte("Job skipped: previous run still in progress"))
+	case models.StatusQueued:
+		if j.Concurrency == models.ConcurrencyReplace {
+			w.Write([]byte("Job restarting: previous run cancelled"))
+		} else {
+			w.Write([]byte("Job queued behind the current run"))
+		}
+	default:
+		w.Write([]byte("Job started in background"))
+	}
+}
+
+// POST /runs/{id}/cancel
+func cancelRunHandler(w http.ResponseWriter, r *http.Request) {
+	runID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return
+	}
+
+	jobID, err := runJobID(runID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Run not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !authorize(w, r, models.RoleOperator, jobID) {
+		return
+	}
+
+	if err := jobs.CancelRun(runID, actor(r)); errors.Is(err, jobs.ErrRunNotActive) {
+		http.Error(w, "Run is not running", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to cancel run: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	recordRunAudit(r, models.AuditCancel, jobID, runID)
+
+	w.Write([]byte("Run cancelled"))
 }
 
 // deleteJobHandler deletes a job from DB and cron scheduler
@@ -165,8 +249,11 @@ func deleteJobHandler(w http.ResponseWriter, r *http.Request) {
 
 	idStr := strings.TrimPrefix(r.URL.Path, "/delete/")
 	jobID, _ := strconv.Atoi(idStr)
+	if !authorize(w, r, models.RoleAdmin, jobID) {
+		return
+	}
 
-	if err := DeleteJob(jobID, true); err != nil {
+	if err := removeJob(r, jobID); err != nil {
 		http.Error(w, "Failed to delete job: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -194,59 +281,82 @@ func outputHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    if r.URL.Query().Get("download") == "1" {
-        w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-        w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"run_%d.log\"", runID))
-    } else {
-        w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+    // Optional filter to a single stream
+    stream := r.URL.Query().Get("stream")
+    if stream != "" && stream != jobs.StreamStdout && stream != jobs.StreamStderr {
+        http.Error(w, "Invalid stream, use stdout or stderr", http.StatusBadRequest)
+        return
     }
 
-    // Stream SQLite preview first
-    var preview string
-    err = db.DB.QueryRow("SELECT output FROM job_runs WHERE id = ?", runID).Scan(&preview)
-    if err != nil && !errors.Is(err, sql.ErrNoRows) {
+    run, j, preview, err := getRunWithOutput(runID)
+    if errors.Is(err, sql.ErrNoRows) {
+        http.Error(w, "Run not found", http.StatusNotFound)
+        return
+    } else if err != nil {
         http.Error(w, "Database error", http.StatusInternalServerError)
         return
     }
-
-    contentWritten := false
-
-    if preview != "" {
-        _, _ = io.WriteString(w, preview)
-        contentWritten = true
+    if !authorize(w, r, models.RoleViewer, j.ID) {
+        return
     }
 
-    // 2️⃣ Stream remaining disk log, skipping preview
-    logFilePath := fmt.Sprintf("/logs/%d.log", runID)
-    f, err := os.Open(logFilePath)
-    if err == nil { // only try to read if file exists
-        defer f.Close()
-        if _, err := f.Seek(int64(len(preview)), io.SeekStart); err != nil {
-            log.Printf("Failed to seek disk log: %v", err)
+    download := r.URL.Query().Get("download") == "1"
+    if download || r.URL.Query().Get("format") == "text" {
+        w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+        if download {
+            w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"run_%d.log\"", runID))
         }
 
-        buf := make([]byte, 64*1024)
-        for {
-            n, err := f.Read(buf)
-            if n > 0 {
-                _, _ = w.Write(buf[:n])
-                w.(http.Flusher).Flush()
-                contentWritten = true
-            }
-            if err != nil {
-                if err != io.EOF {
-                    log.Printf("Error reading log file: %v", err)
-                }
-                break
+        written, err := forEachOutputLine(runID, preview, func(_ int, lineStream, text string) error {
+            if stream != "" && lineStream != stream {
+                return nil
             }
+            _, err := io.WriteString(w, text+"\n")
+            return err
+        })
+        if err != nil {
+            log.Printf("Error streaming log output: %v", err)
+        }
+        if !written {
+            _, _ = io.WriteString(w, "⚠️ No log output available for this run.\n")
+        }
+        return
+    }
+
+    tmpl, err := createTemplate().ParseFS(templatesFS, "templates/output.html")
+    if err != nil {
+        http.Error(w, fmt.Sprintf("Template parse error: %v", err), http.StatusInternalServerError)
+        return
+    }
+
+    data := pageData(r, map[string]interface{}{"Run": run, "Job": j, "Stream": stream})
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    if err := tmpl.ExecuteTemplate(w, "outputHeader", data); err != nil {
+        log.Printf("Template execution error: %v", err)
+        return
+    }
+
+    // Stream lines as they are read so large logs are not held in memory
+    written, err := forEachOutputLine(runID, preview, func(n int, lineStream, text string) error {
+        if stream != "" && lineStream != stream {
+            return nil
         }
+        _, err := fmt.Fprintf(w,
+            "<div class=\"log-line log-%s\" id=\"L%d\"><a class=\"line-no\" href=\"#L%d\">%d</a><span class=\"line-text\">%s</span></div>\n",
+            lineStream, n, n, n, html.EscapeString(text))
+        return err
+    })
+    if err != nil {
+        log.Printf("Error streaming log output: %v", err)
+    }
+    if !written {
+        _, _ = io.WriteString(w, "<div class=\"log-empty\">⚠️ No log output available for this run.</div>\n")
     }
 
-    // 3️⃣ If nothing was written, show placeholder
-    if !contentWritten {
-        _, _ = io.WriteString(w, "⚠️ No log output available for this run.\n")
+    if err := tmpl.ExecuteTemplate(w, "outputFooter", data); err != nil {
+        log.Printf("Template execution error: %v", err)
     }
-	utils.CleanupEmptyLogs("./logs")
+	utils.CleanupEmptyLogs(jobs.LogDir, jobs.LogInUse)
 }
 
 
@@ -263,12 +373,11 @@ func editJobFormHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid job ID", http.StatusBadRequest)
 		return
 	}
+	if !authorize(w, r, models.RoleAdmin, id) {
+		return
+	}
 
-	var j models.Job
-	var lastRun,Created,Updated sql.NullString
-	err = db.DB.QueryRow(`SELECT j.id, j.name, j.schedule, j.command, j.status, j.created_at, j.updated_at,
-						COALESCE((SELECT MAX(r.run_at) FROM job_runs r WHERE r.job_id = j.id), '') AS last_run 
-						FROM jobs j WHERE id = ?`, id).Scan(&j.ID, &j.Name, &j.Schedule, &j.Command, &j.Status, &Created, &Updated, &lastRun)
+	j, err := db.GetJob(id)
 	if errors.Is(err, sql.ErrNoRows) {
 		http.Error(w, "Job not found", http.StatusNotFound)
 		return
@@ -277,18 +386,25 @@ func editJobFormHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	j.CreatedAt = utils.NullTimeAgo(Created)
-	j.UpdatedAt = utils.NullTimeAgo(Updated)
-	j.LastRun   = utils.NullTimeAgo(lastRun)
+	history, err := jobHistory(j)
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
 
-	tmpl := template.Must(template.ParseFS(
+	tmpl := template.Must(createTemplate().ParseFS(
 	templatesFS,
 	"templates/base.html",
 	"templates/edit.html",
     "templates/modals/schedule_helper.html",
     "templates/modals/delete_confirm.html",
 	))
-	if err := tmpl.ExecuteTemplate(w, "base", map[string]interface{}{"Job": j}); err != nil {
+	if err := tmpl.ExecuteTemplate(w, "base", pageData(r, map[string]interface{}{
+		"Job":        j,
+		"History":    history,
+		"ServerZone": serverZone(),
+		"Retention":  jobs.DefaultRetention,
+	})); err != nil {
 		http.Error(w, fmt.Sprintf("Template error: %v", err), http.StatusInternalServerError)
 	}
 }
@@ -301,6 +417,9 @@ func editJobSubmitHandler(w http.ResponseWriter, r *http.Request) {
 
 	idStr := strings.TrimPrefix(r.URL.Path, "/edit/")
 	jobID, _ := strconv.Atoi(idStr)
+	if !authorize(w, r, models.RoleAdmin, jobID) {
+		return
+	}
 
 	job, err := utils.ParseJobForm(r)
 	if err != nil {
@@ -309,7 +428,7 @@ func editJobSubmitHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	job.ID = jobID
 
-	if err := UpsertJob(job); err != nil {
+	if err := saveJob(r, job); err != nil {
 		http.Error(w, "Failed to update job: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -323,6 +442,9 @@ func createTemplate() *template.Template {
     return template.New("").Funcs(template.FuncMap{
         "formatDate": utils.FormatDate,
         "formatTime": utils.FormatTime,
+        "fileSize":   utils.FormatFileSize,
+        "jsonValue":  jsonValue,
+        "highlight":  highlight,
     })
 }
 
@@ -338,11 +460,12 @@ func logsHandler(w http.ResponseWriter, r *http.Request) {
         http.Error(w, "Invalid job ID", http.StatusBadRequest)
         return
     }
+    if !authorize(w, r, models.RoleViewer, id) {
+        return
+    }
 
     // Fetch job info
-    var j models.Job
-    err = db.DB.QueryRow("SELECT id, name FROM jobs WHERE id = ?", id).
-        Scan(&j.ID, &j.Name)
+    j, err := db.GetJob(id)
     if errors.Is(err, sql.ErrNoRows) {
         http.Error(w, "Job not found", http.StatusNotFound)
         return
@@ -358,7 +481,17 @@ func logsHandler(w http.ResponseWriter, r *http.Request) {
             run_at, 
             status, 
             duration_ms,
-            LENGTH(output) as output_size
+            LENGTH(output) as output_size,
+            COALESCE(cancelled_by, ''),
+            attempt,
+            COALESCE(parent_run_id, 0),
+            exit_code,
+            COALESCE(signal, ''),
+            trigger,
+            COALESCE(job_version, 0),
+            COALESCE(log_bytes, 0),
+            COALESCE(log_stored_bytes, 0),
+            log_compressed
         FROM job_runs 
         WHERE job_id = ? 
         ORDER BY run_at DESC
@@ -369,12 +502,16 @@ func logsHandler(w http.ResponseWriter, r *http.Request) {
     }
     defer rows.Close()
 
+    loc := utils.LoadLocation(j.TimeZone)
+    canOperate := auth.UserFromContext(r.Context()).Can(models.RoleOperator, j.ID)
+
     var logs []models.Run
     for rows.Next() {
         var logEntry models.Run
         var runAtStr string
         var durationMs sql.NullInt64
         var outputSize sql.NullInt64
+        var exitCode sql.NullInt64
         
         if err := rows.Scan(
             &logEntry.ID,
@@ -382,6 +519,16 @@ func logsHandler(w http.ResponseWriter, r *http.Request) {
             &logEntry.Status, 
             &durationMs,
             &outputSize,
+            &logEntry.CancelledBy,
+            &logEntry.Attempt,
+            &logEntry.ParentID,
+            &exitCode,
+            &logEntry.Signal,
+            &logEntry.Trigger,
+            &logEntry.JobVersion,
+            &logEntry.LogBytes,
+            &logEntry.LogStoredBytes,
+            &logEntry.LogCompressed,
         ); err != nil {
             log.Printf("Failed to scan log row: %v", err)
             continue
@@ -392,9 +539,16 @@ func logsHandler(w http.ResponseWriter, r *http.Request) {
             log.Printf("Failed to parse run_at: %v", err)
             continue
         }
-        logEntry.RunAt = t
+        logEntry.RunAt = t.In(loc)
+        logEntry.CanCancel = canOperate &&
+            (logEntry.Status == models.StatusRunning || logEntry.Status == models.StatusQueued)
 
         
+        if exitCode.Valid {
+            code := int(exitCode.Int64)
+            logEntry.ExitCode = &code
+        }
+
         // Convert duration to human-readable format
         if durationMs.Valid {
             logEntry.Duration = utils.FormatDuration(durationMs.Int64)
@@ -412,6 +566,8 @@ func logsHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    logs = groupAttempts(logs)
+
     // Render template
 	tmpl := createTemplate()
 	tmpl, err = tmpl.ParseFS(templatesFS,
@@ -424,10 +580,10 @@ func logsHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
-    if err := tmpl.ExecuteTemplate(w, "base", map[string]interface{}{
+    if err := tmpl.ExecuteTemplate(w, "base", pageData(r, map[string]interface{}{
         "Job":  j,
         "Logs": logs,
-    }); err != nil {
+    })); err != nil {
         log.Printf("Template execution error: %v", err)
         http.Error(w, "Template execution failed", http.StatusInternalServerError)
     }
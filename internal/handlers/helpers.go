@@ -1,11 +1,19 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/abhilashreddysh/croncraft/internal/db"
 	"github.com/abhilashreddysh/croncraft/internal/jobs"
 	"github.com/abhilashreddysh/croncraft/internal/models"
+	"github.com/abhilashreddysh/croncraft/internal/utils"
 )
 
 // UpsertJob inserts or updates a job in DB and updates the cron schedule
@@ -19,23 +27,58 @@ func UpsertJob(job *models.Job) error {
 	if job.ID == 0 {
 		// Insert new job
 		res, err := db.DB.Exec(
-			"INSERT INTO jobs(name, schedule, command, status) VALUES(?, ?, ?, ?)",
-			job.Name, job.Schedule, job.Command, statusInt,
+			`INSERT INTO jobs(name, schedule, command, status, timeout_seconds, concurrency_policy,
+				max_attempts, retry_backoff, retry_delay_seconds, retry_exit_codes, rerun_interrupted,
+				misfire_policy, misfire_max, timezone, keep_runs, keep_days, keep_failed_days, max_log_mb)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			job.Name, job.Schedule, job.Command, statusInt, job.TimeoutSeconds, job.Concurrency,
+			job.MaxAttempts, job.RetryBackoff, job.RetryDelaySeconds, job.RetryExitCodes, job.RerunInterrupted,
+			job.MisfirePolicy, job.MisfireMax, job.TimeZone, job.KeepRuns, job.KeepDays, job.KeepFailedDays, job.MaxLogMB,
 		)
 		if err != nil {
 			return err
 		}
 		id, _ := res.LastInsertId()
 		job.ID = int(id)
+		job.Version = 1
 	} else {
-		// Update existing job
-		_, err := db.DB.Exec(
-			"UPDATE jobs SET name = ?, schedule = ?, command = ?, status = ? WHERE id = ?",
-			job.Name, job.Schedule, job.Command, statusInt, job.ID,
+		// Update existing job, keeping the definition it replaces
+		before, err := db.GetJob(job.ID)
+		if err != nil {
+			return err
+		}
+		job.Version = before.Version
+
+		tx, err := db.DB.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if !db.SameDefinition(*before, *job) {
+			if err := db.SaveJobVersion(tx, *before); err != nil {
+				return err
+			}
+			job.Version++
+		}
+
+		_, err = tx.Exec(
+			`UPDATE jobs SET name = ?, schedule = ?, command = ?, status = ?, timeout_seconds = ?, concurrency_policy = ?,
+				max_attempts = ?, retry_backoff = ?, retry_delay_seconds = ?, retry_exit_codes = ?,
+				rerun_interrupted = ?, misfire_policy = ?, misfire_max = ?, timezone = ?, version = ?,
+				keep_runs = ?, keep_days = ?, keep_failed_days = ?, max_log_mb = ?
+			WHERE id = ?`,
+			job.Name, job.Schedule, job.Command, statusInt, job.TimeoutSeconds, job.Concurrency,
+			job.MaxAttempts, job.RetryBackoff, job.RetryDelaySeconds, job.RetryExitCodes,
+			job.RerunInterrupted, job.MisfirePolicy, job.MisfireMax, job.TimeZone, job.Version,
+			job.KeepRuns, job.KeepDays, job.KeepFailedDays, job.MaxLogMB, job.ID,
 		)
 		if err != nil {
 			return err
 		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
 	}
 
 	// Update cron
@@ -68,6 +111,21 @@ func DeleteJob(jobID int, removeLogs bool) error {
 	}
 	defer tx.Rollback()
 
+	var runIDs []int64
+	rows, err := tx.Query("SELECT id FROM job_runs WHERE job_id = ?", jobID)
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		runIDs = append(runIDs, id)
+	}
+	rows.Close()
+
 	_, err = tx.Exec("DELETE FROM job_runs WHERE job_id = ?", jobID)
 	if err != nil {
 		return err
@@ -78,9 +136,132 @@ func DeleteJob(jobID int, removeLogs bool) error {
 		return err
 	}
 
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	// Only the job's own logs, as the log directory may hold other files
 	if removeLogs {
-		_ = os.RemoveAll("./logs") // optional: cleanup all logs or per-job
+		for _, id := range runIDs {
+			_ = jobs.RemoveLog(id)
+		}
 	}
+	return nil
+}
+
+// clientIP returns the address of the client that made the request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
 
-	return tx.Commit()
+// groupAttempts nests retry attempts under the first attempt of their run,
+// keeping the order of runs. Attempts whose first attempt is no longer
+// listed are kept as runs of their own.
+func groupAttempts(runs []models.Run) []models.Run {
+	index := make(map[int]int)
+	for i, run := range runs {
+		if run.ParentID == 0 {
+			index[run.ID] = i
+		}
+	}
+
+	var grouped []models.Run
+	retries := make(map[int][]models.Run)
+	for _, run := range runs {
+		if _, ok := index[run.ParentID]; ok {
+			retries[run.ParentID] = append(retries[run.ParentID], run)
+			continue
+		}
+		grouped = append(grouped, run)
+	}
+
+	for i, run := range grouped {
+		attempts := retries[run.ID]
+		slices.SortFunc(attempts, func(a, b models.Run) int { return a.Attempt - b.Attempt })
+		grouped[i].Retries = attempts
+	}
+	return grouped
+}
+
+// getRunWithOutput loads a run, its job and the output preview stored in the DB
+func getRunWithOutput(runID int) (models.Run, models.Job, string, error) {
+	var run models.Run
+	var j models.Job
+	var runAt, preview string
+	var durationMs, exitCode sql.NullInt64
+
+	err := db.DB.QueryRow(`
+		SELECT r.id, r.run_at, r.status, r.duration_ms, r.exit_code, COALESCE(r.signal, ''),
+		       COALESCE(r.cancelled_by, ''), r.attempt, r.trigger, COALESCE(r.job_version, 0),
+		       COALESCE(r.output, ''), j.id, j.name
+		FROM job_runs r JOIN jobs j ON j.id = r.job_id
+		WHERE r.id = ?`, runID).
+		Scan(&run.ID, &runAt, &run.Status, &durationMs, &exitCode, &run.Signal,
+			&run.CancelledBy, &run.Attempt, &run.Trigger, &run.JobVersion, &preview, &j.ID, &j.Name)
+	if err != nil {
+		return run, j, "", err
+	}
+
+	run.RunAt, _ = time.Parse(time.RFC3339, runAt)
+	if durationMs.Valid {
+		run.Duration = utils.FormatDuration(durationMs.Int64)
+	}
+	if exitCode.Valid {
+		code := int(exitCode.Int64)
+		run.ExitCode = &code
+	}
+
+	return run, j, preview, nil
+}
+
+// runJobID returns the ID of the job a run belongs to
+func runJobID(runID int64) (int, error) {
+	var jobID int
+	err := db.DB.QueryRow("SELECT job_id FROM job_runs WHERE id = ?", runID).Scan(&jobID)
+	return jobID, err
+}
+
+// forEachOutputLine calls fn for every line of a run's output with its line
+// number and stream. It reads the full log file and falls back to the DB
+// preview when the file is gone. It reports whether there was any output.
+func forEachOutputLine(runID int, preview string, fn func(n int, stream, text string) error) (bool, error) {
+	f, err := jobs.OpenLog(int64(runID), 0)
+	if errors.Is(err, os.ErrNotExist) {
+		n := 0
+		for line := range strings.Lines(preview) {
+			n++
+			if err := fn(n, jobs.StreamStdout, strings.TrimSuffix(line, "\n")); err != nil {
+				return true, err
+			}
+		}
+		return n > 0, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	n := 0
+	scanner := jobs.NewLogScanner(f)
+	for scanner.Scan() {
+		n++
+		stream, text := jobs.ParseLogLine(scanner.Text())
+		if err := fn(n, stream, text); err != nil {
+			return true, err
+		}
+	}
+	return n > 0, scanner.Err()
+}
+
+// serverZone names the server's time zone, which schedules without a time
+// zone of their own run in
+func serverZone() string {
+	if name := utils.ServerLocation.String(); name != "Local" {
+		return name
+	}
+	name, _ := time.Now().In(utils.ServerLocation).Zone()
+	return name
 }
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/abhilashreddysh/croncraft/internal/auth"
+	"github.com/abhilashreddysh/croncraft/internal/jobs"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+)
+
+// schemaEnums lists the allowed values of string fields, keyed by schema
+// name and JSON field name
+var schemaEnums = map[string][]string{
+	"Job.concurrency":    {models.ConcurrencyAllow, models.ConcurrencySkip, models.ConcurrencyQueue, models.ConcurrencyReplace},
+	"Job.retry_backoff":  {models.BackoffFixed, models.BackoffExponential},
+	"Job.misfire_policy": {models.MisfireIgnore, models.MisfireOnce, models.MisfireAll},
+	"Run.status": {models.StatusRunning, models.StatusSuccess, models.StatusFailed, models.StatusTimeout,
+		models.StatusCancelled, models.StatusSkipped, models.StatusQueued, models.StatusInterrupted},
+	"Run.trigger":       {models.TriggerSchedule, models.TriggerManual, models.TriggerCatchUp, models.TriggerRecovery},
+	"OutputLine.stream": {jobs.StreamStdout, jobs.StreamStderr},
+	"AuditEvent.action": models.AuditActions,
+}
+
+var pathParamPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+// GET /api/v1/openapi.json
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPISpec())
+}
+
+// openAPISpec builds the OpenAPI 3 document of the /api/v1 endpoints from
+// apiV1Routes and the types they exchange
+func openAPISpec() map[string]any {
+	schemas := map[string]any{}
+	errorResponse := map[string]any{
+		"description": "Error",
+		"content":     jsonContent(schemaRef(reflect.TypeOf(apiError{}), schemas)),
+	}
+
+	paths := map[string]map[string]any{}
+	for _, route := range apiV1Routes {
+		var params []map[string]any
+		for _, m := range pathParamPattern.FindAllStringSubmatch(route.Path, -1) {
+			params = append(params, map[string]any{
+				"name":     m[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "integer"},
+			})
+		}
+		for _, p := range route.Query {
+			schema := map[string]any{"type": p.Type}
+			if p.Format != "" {
+				schema["format"] = p.Format
+			}
+			params = append(params, map[string]any{
+				"name":        p.Name,
+				"in":          "query",
+				"description": p.Description,
+				"schema":      schema,
+			})
+		}
+
+		success := map[string]any{"description": http.StatusText(route.Status)}
+		if route.Response != nil {
+			success["content"] = jsonContent(schemaRef(reflect.TypeOf(route.Response), schemas))
+		}
+
+		op := map[string]any{
+			"operationId": route.OperationID,
+			"summary":     route.Summary,
+			"tags":        []string{strings.Split(route.Path, "/")[3]},
+			"responses": map[string]any{
+				strconv.Itoa(route.Status): success,
+				"default":                  errorResponse,
+			},
+		}
+		if params != nil {
+			op["parameters"] = params
+		}
+		if route.Body != nil {
+			op["requestBody"] = map[string]any{
+				"required": true,
+				"content":  jsonContent(schemaRef(reflect.TypeOf(route.Body), schemas)),
+			}
+		}
+
+		path := strings.TrimPrefix(route.Path, "/api/v1")
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(route.Method)] = op
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "CronCraft API",
+			"version": "1",
+		},
+		"servers": []map[string]any{{"url": "/api/v1"}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"apiToken": map[string]any{"type": "http", "scheme": "bearer"},
+				"session":  map[string]any{"type": "apiKey", "in": "cookie", "name": auth.SessionCookie},
+			},
+		},
+		"security": []map[string][]string{{"apiToken": {}}, {"session": {}}},
+	}
+}
+
+func jsonContent(schema map[string]any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+// schemaRef returns the schema of t, adding named structs to schemas and
+// referring to them
+func schemaRef(t reflect.Type, schemas map[string]any) map[string]any {
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Pointer:
+		schema := schemaRef(t.Elem(), schemas)
+		schema["nullable"] = true
+		return schema
+	case t.Kind() == reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaRef(t.Elem(), schemas)}
+	case t.Kind() == reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaRef(t.Elem(), schemas)}
+	case t.Kind() == reflect.Interface:
+		return map[string]any{} // any JSON value
+	case t.Kind() == reflect.Struct:
+		name := schemaName(t)
+		if _, ok := schemas[name]; !ok {
+			schemas[name] = nil // placeholder for recursive types
+			schemas[name] = structSchema(name, t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	case t.Kind() == reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		if t.Size() == 8 && t.Kind() != reflect.Int {
+			return map[string]any{"type": "integer", "format": "int64"}
+		}
+		return map[string]any{"type": "integer"}
+	default:
+		return map[string]any{"type": "string"}
+	}
+}
+
+// structSchema describes the JSON fields of a struct
+func structSchema(name string, t reflect.Type, schemas map[string]any) map[string]any {
+	properties := map[string]any{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		field, _, _ := strings.Cut(tag, ",")
+		if !f.IsExported() || field == "-" {
+			continue
+		}
+		if field == "" {
+			field = f.Name
+		}
+
+		schema := schemaRef(f.Type, schemas)
+		if values, ok := schemaEnums[name+"."+field]; ok {
+			schema["enum"] = values
+		}
+		if f.Tag.Get("openapi") == "readonly" {
+			if _, ok := schema["$ref"]; !ok {
+				schema["readOnly"] = true
+			}
+		}
+		properties[field] = schema
+	}
+	return map[string]any{"type": "object", "properties": properties}
+}
+
+// schemaName names the schema of a struct after its Go type
+func schemaName(t reflect.Type) string {
+	name := []rune(t.Name())
+	name[0] = unicode.ToUpper(name[0])
+	return string(name)
+}
+
+// GET /api/docs
+func apiDocsHandler(w http.ResponseWriter, r *http.Request) {
+	tmpl, err := template.ParseFS(templatesFS,
+		"templates/base.html",
+		"templates/api_docs.html",
+	)
+	if err != nil {
+		http.Error(w, "Template parse error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := tmpl.ExecuteTemplate(w, "base", pageData(r, map[string]interface{}{"ActivePage": "api"})); err != nil {
+		http.Error(w, "Template execute error: "+err.Error(), http.StatusInternalServerError)
+	}
+}
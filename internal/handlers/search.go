@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/abhilashreddysh/croncraft/internal/auth"
+	"github.com/abhilashreddysh/croncraft/internal/db"
+	"github.com/abhilashreddysh/croncraft/internal/jobs"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+)
+
+// searchPage is the body of the output search API response
+type searchPage struct {
+	Matches []models.OutputMatch `json:"matches"`
+	Page    int                  `json:"page"`
+	PerPage int                  `json:"per_page"`
+	Total   int                  `json:"total"` // lines matching the search on all pages
+}
+
+var searchParams = []apiParam{
+	{"q", "string", "", `Words the lines contain; "quoted words" form a phrase and a trailing * matches a prefix`},
+	{"job_id", "integer", "", "Only lines of runs of this job"},
+	{"status", "string", "", "Only lines of runs with this status"},
+	{"since", "string", "date-time", "Only lines of runs started at or after this time"},
+	{"until", "string", "date-time", "Only lines of runs started before this time"},
+	{"page", "integer", "", "Page to return, starting at 1"},
+	{"per_page", "integer", "", "Lines per page, at most 200"},
+}
+
+// runStatuses lists the statuses the search can be filtered by
+var runStatuses = []string{models.StatusSuccess, models.StatusFailed, models.StatusTimeout,
+	models.StatusCancelled, models.StatusInterrupted, models.StatusSkipped}
+
+// GET /search
+func searchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !authorize(w, r, models.RoleViewer, 0) {
+		return
+	}
+
+	// Offer the jobs the account may see as a filter
+	all, err := db.GetJobsFromDB()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	u := auth.UserFromContext(r.Context())
+	var visible []models.Job
+	for _, j := range all {
+		if u.CanSee(j.ID) {
+			visible = append(visible, j)
+		}
+	}
+
+	data := map[string]interface{}{
+		"ActivePage": "search",
+		"Jobs":       visible,
+		"Statuses":   runStatuses,
+		"Query":      r.URL.Query(),
+	}
+
+	if r.URL.Query().Get("q") != "" {
+		filter, page, perPage, err := parseSearchQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if filter.JobID != 0 && !authorize(w, r, models.RoleViewer, filter.JobID) {
+			return
+		}
+
+		matches, total, err := jobs.SearchOutput(filter, perPage, (page-1)*perPage)
+		if err != nil && !errors.Is(err, jobs.ErrEmptySearch) {
+			http.Error(w, fmt.Sprintf("Database error: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		// Link to the neighbouring pages with the same search
+		query := r.URL.Query()
+		pageURL := func(p int) string {
+			query.Set("page", strconv.Itoa(p))
+			return "/search?" + query.Encode()
+		}
+		data["Searched"] = true
+		data["Matches"] = matches
+		data["Page"] = page
+		data["Total"] = total
+		if page > 1 {
+			data["PrevURL"] = pageURL(page - 1)
+		}
+		if page*perPage < total {
+			data["NextURL"] = pageURL(page + 1)
+		}
+	}
+
+	tmpl, err := createTemplate().ParseFS(templatesFS,
+		"templates/base.html",
+		"templates/search.html",
+	)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Template parse error: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.ExecuteTemplate(w, "base", pageData(r, data)); err != nil {
+		log.Printf("Template execution error: %v", err)
+	}
+}
+
+// GET /api/v1/search
+func apiSearchOutput(w http.ResponseWriter, r *http.Request) {
+	if !apiAuthorize(w, r, models.RoleViewer, 0) {
+		return
+	}
+
+	filter, page, perPage, err := parseSearchQuery(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.JobID != 0 && !apiAuthorize(w, r, models.RoleViewer, filter.JobID) {
+		return
+	}
+
+	matches, total, err := jobs.SearchOutput(filter, perPage, (page-1)*perPage)
+	if errors.Is(err, jobs.ErrEmptySearch) {
+		writeJSONError(w, http.StatusBadRequest, "q must contain at least one word")
+		return
+	} else if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, "database error")
+		return
+	}
+	writeJSON(w, http.StatusOK, searchPage{Matches: matches, Page: page, PerPage: perPage, Total: total})
+}
+
+// parseSearchQuery reads the search, its filters and the page from the
+// query. Accounts limited to some jobs only search those.
+func parseSearchQuery(r *http.Request) (jobs.SearchFilter, int, int, error) {
+	q := r.URL.Query()
+	f := jobs.SearchFilter{
+		Query:  q.Get("q"),
+		Status: q.Get("status"),
+		JobIDs: auth.UserFromContext(r.Context()).GrantedJobs(),
+	}
+
+	jobID, err := queryInt(r, "job_id", 0, 0, 0)
+	if err != nil {
+		return f, 0, 0, err
+	}
+	f.JobID = jobID
+
+	f.Since, f.Until, err = parseTimeRange(r)
+	if err != nil {
+		return f, 0, 0, err
+	}
+
+	page, err := queryInt(r, "page", 1, 1, 0)
+	if err != nil {
+		return f, 0, 0, err
+	}
+	perPage, err := queryInt(r, "per_page", defaultRunsPerPage, 1, maxRunsPerPage)
+	if err != nil {
+		return f, 0, 0, err
+	}
+	return f, page, perPage, nil
+}
+
+// highlight marks the HTML of a search match, escaped by the search, as
+// safe to include in a page
+func highlight(s string) template.HTML {
+	return template.HTML(s)
+}
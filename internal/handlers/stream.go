@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abhilashreddysh/croncraft/internal/jobs"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+)
+
+const (
+	// streamPollInterval is how often a followed log file is checked for
+	// new lines
+	streamPollInterval = 250 * time.Millisecond
+
+	// streamStatusInterval is how often the run's status is checked, for
+	// runs that end without RunJob, e.g. after a restart
+	streamStatusInterval = 2 * time.Second
+
+	// streamKeepAlive is how often a comment is sent while the log is
+	// quiet, so proxies do not close the connection
+	streamKeepAlive = 15 * time.Second
+)
+
+var (
+	// streamsDone is closed by EndStreams to end every open log stream
+	streamsDone     = make(chan struct{})
+	streamsDoneOnce sync.Once
+)
+
+// EndStreams ends the open log streams. http.Server.Shutdown waits for
+// requests to finish without cancelling them, so it must be registered with
+// RegisterOnShutdown for followed runs not to hold up shutdown.
+func EndStreams() {
+	streamsDoneOnce.Do(func() { close(streamsDone) })
+}
+
+// streamLine is the data of a line event of the log stream
+type streamLine struct {
+	N      int    `json:"n"` // line number, also sent as the event ID
+	Stream string `json:"stream"`
+	Text   string `json:"text"`
+}
+
+// GET /logs/{id}/stream follows the output of a run as Server-Sent Events,
+// like tail -f. Every line is a "line" event. Once the run has finished, a
+// "status" event carries the run and the stream ends. Clients reconnecting
+// with Last-Event-ID continue after that line.
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	runID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid run ID", http.StatusBadRequest)
+		return
+	}
+
+	stream := r.URL.Query().Get("stream")
+	if stream != "" && stream != jobs.StreamStdout && stream != jobs.StreamStderr {
+		http.Error(w, "Invalid stream, use stdout or stderr", http.StatusBadRequest)
+		return
+	}
+
+	run, err := loadRun(runID)
+	if errors.Is(err, errRunNotFound) {
+		http.Error(w, "Run not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !authorize(w, r, models.RoleViewer, run.JobID) {
+		return
+	}
+
+	// Watch before looking at the status, so the end of the run is not missed
+	watch, stopWatching := jobs.WatchRun(runID)
+	defer stopWatching()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	rc := http.NewResponseController(w)
+
+	after, _ := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	n := 0
+	send := func(lineStream, text string) error {
+		n++
+		if n <= after || (stream != "" && lineStream != stream) {
+			return nil
+		}
+		return writeEvent(w, "line", n, streamLine{N: n, Stream: lineStream, Text: text})
+	}
+
+	tail := &logTail{runID: runID}
+
+	poll := time.NewTicker(streamPollInterval)
+	defer poll.Stop()
+	statusCheck := time.NewTicker(streamStatusInterval)
+	defer statusCheck.Stop()
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	fmt.Fprint(w, "retry: 3000\n\n")
+	finished := runFinished(run.Status)
+	for {
+		lines, err := tail.read(finished)
+		if err != nil {
+			log.Printf("Failed to follow log of run %d: %v", runID, err)
+			return
+		}
+		for _, line := range lines {
+			lineStream, text := jobs.ParseLogLine(line)
+			if err := send(lineStream, text); err != nil {
+				return
+			}
+		}
+
+		if finished {
+			break
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-streamsDone:
+			// Clients reconnect after retry and continue where they left off
+			return
+		case <-watch:
+			watch = nil
+			finished = true
+		case <-statusCheck.C:
+			if run, err = loadRun(runID); err != nil {
+				return
+			}
+			finished = runFinished(run.Status)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-poll.C:
+		}
+	}
+
+	// Runs that never got a log file, e.g. ones that failed to start, only
+	// have the output kept in the DB
+	if !tail.opened {
+		_, _, preview, err := getRunWithOutput(int(runID))
+		if err == nil {
+			_, err = forEachOutputLine(int(runID), preview, func(_ int, lineStream, text string) error {
+				return send(lineStream, text)
+			})
+		}
+		if err != nil {
+			log.Printf("Failed to send output of run %d: %v", runID, err)
+			return
+		}
+	}
+
+	if run, err = loadRun(runID); err != nil {
+		return
+	}
+	if err := writeEvent(w, "status", 0, run); err == nil {
+		rc.Flush()
+	}
+}
+
+// errRunNotFound is returned by loadRun for unknown runs
+var errRunNotFound = errors.New("run not found")
+
+// loadRun returns a run as listed by the API
+func loadRun(runID int64) (models.Run, error) {
+	runs, err := queryRuns(" WHERE r.id = ?", runID)
+	if err != nil {
+		return models.Run{}, err
+	}
+	if len(runs) == 0 {
+		return models.Run{}, errRunNotFound
+	}
+	return runs[0], nil
+}
+
+// runFinished reports whether a run with the status has ended
+func runFinished(status string) bool {
+	return status != models.StatusRunning && status != models.StatusQueued
+}
+
+// writeEvent writes one Server-Sent Event with JSON data. An id of 0 is
+// left out.
+func writeEvent(w io.Writer, event string, id int, data any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if id > 0 {
+		if _, err := fmt.Fprintf(w, "id: %d\n", id); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+	return err
+}
+
+// logTail reads the lines appended to a run's log since the last read
+type logTail struct {
+	runID   int64
+	offset  int64  // bytes of the log read so far
+	partial string // start of a line that is still being written
+	opened  bool
+}
+
+// read returns the complete lines written since the last call, without
+// their newlines. A log that does not exist yet has no lines. With last
+// set, a final line without a newline is returned as well.
+func (t *logTail) read(last bool) ([]string, error) {
+	f, err := jobs.OpenLog(t.runID, t.offset)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t.opened = true
+
+	var lines []string
+	r := bufio.NewReader(f)
+	for {
+		s, err := r.ReadString('\n')
+		t.offset += int64(len(s))
+		if errors.Is(err, io.EOF) {
+			t.partial += s
+			if last && t.partial != "" {
+				lines = append(lines, t.partial)
+				t.partial = ""
+			}
+			return lines, nil
+		} else if err != nil {
+			return lines, err
+		}
+		lines = append(lines, strings.TrimSuffix(t.partial+s, "\n"))
+		t.partial = ""
+	}
+}
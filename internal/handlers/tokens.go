@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/abhilashreddysh/croncraft/internal/auth"
+	"github.com/abhilashreddysh/croncraft/internal/db"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+	"github.com/abhilashreddysh/croncraft/internal/utils"
+)
+
+// GET and POST /tokens
+func tokensHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, models.RoleAdmin, 0) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		renderTokens(w, r, "", "")
+
+	case http.MethodPost:
+		token, err := createTokenFromForm(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			renderTokens(w, r, "Failed to create token: "+err.Error(), "")
+			return
+		}
+		log.Printf("API token %q created by %s", r.FormValue("name"), actor(r))
+		renderTokens(w, r, "", token)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// POST /tokens/{id}/revoke
+func revokeTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, models.RoleAdmin, 0) {
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := auth.RevokeToken(id); errors.Is(err, auth.ErrTokenNotFound) {
+		http.Error(w, "Token not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Failed to revoke token: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("API token %d revoked by %s", id, actor(r))
+
+	http.Redirect(w, r, "/tokens", http.StatusSeeOther)
+}
+
+// createTokenFromForm mints a token from the new token form. The expiry is
+// a date, and the token is valid through the end of that day.
+func createTokenFromForm(r *http.Request) (string, error) {
+	if err := r.ParseForm(); err != nil {
+		return "", err
+	}
+
+	var jobIDs []int
+	for _, v := range r.Form["job_id"] {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return "", errors.New("invalid job ID")
+		}
+		jobIDs = append(jobIDs, id)
+	}
+
+	var expires time.Time
+	if v := r.FormValue("expires"); v != "" {
+		day, err := time.ParseInLocation("2006-01-02", v, utils.ServerLocation)
+		if err != nil {
+			return "", errors.New("expiry must be a date")
+		}
+		expires = day.AddDate(0, 0, 1)
+	}
+
+	return auth.CreateToken(r.FormValue("name"), r.FormValue("scope"), jobIDs, expires, actor(r))
+}
+
+// renderTokens shows the API tokens page, with a newly created token shown
+// once
+func renderTokens(w http.ResponseWriter, r *http.Request, errMessage, newToken string) {
+	tokens, err := auth.ListTokens()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	jobList, err := db.GetJobsFromDB()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	jobNames := map[int]string{}
+	for _, j := range jobList {
+		jobNames[j.ID] = j.Name
+	}
+
+	tmpl := template.Must(template.ParseFS(templatesFS,
+		"templates/base.html",
+		"templates/tokens.html",
+	))
+	if err := tmpl.ExecuteTemplate(w, "base", pageData(r, map[string]interface{}{
+		"ActivePage": "tokens",
+		"Tokens":     tokens,
+		"Jobs":       jobList,
+		"JobNames":   jobNames,
+		"Scopes":     models.Scopes,
+		"Error":      errMessage,
+		"NewToken":   newToken,
+		"Now":        time.Now(),
+	})); err != nil {
+		log.Printf("Template execution error: %v", err)
+	}
+}
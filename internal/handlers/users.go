@@ -0,0 +1,133 @@
+package handlers
+
+import (
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/abhilashreddysh/croncraft/internal/auth"
+	"github.com/abhilashreddysh/croncraft/internal/db"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+)
+
+// GET and POST /users
+func usersHandler(w http.ResponseWriter, r *http.Request) {
+	if !authorize(w, r, models.RoleAdmin, 0) {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		renderUsers(w, r, "", "")
+
+	case http.MethodPost:
+		u, err := auth.CreateUser(r.FormValue("username"), r.FormValue("password"), r.FormValue("role"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			renderUsers(w, r, "Failed to create account: "+err.Error(), "")
+			return
+		}
+		log.Printf("Account %q created as %s by %s", u.Username, u.Role, actor(r))
+		renderUsers(w, r, "", "Account "+u.Username+" created.")
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// POST /users/{id}/role
+func userRoleHandler(w http.ResponseWriter, r *http.Request) {
+	userAction(w, r, func(userID int) error {
+		return auth.SetRole(userID, r.FormValue("role"))
+	})
+}
+
+// POST /users/{id}/delete
+func userDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	userAction(w, r, func(userID int) error {
+		if userID == auth.UserFromContext(r.Context()).ID {
+			return errors.New("you cannot delete your own account")
+		}
+		return auth.DeleteUser(userID)
+	})
+}
+
+// POST /users/{id}/grants sets the account's role on one job, or removes
+// the grant for an empty role
+func userGrantHandler(w http.ResponseWriter, r *http.Request) {
+	userAction(w, r, func(userID int) error {
+		jobID, err := strconv.Atoi(r.FormValue("job_id"))
+		if err != nil {
+			return errors.New("invalid job ID")
+		}
+		return auth.SetGrant(userID, jobID, r.FormValue("role"))
+	})
+}
+
+// POST /users/{id}/grants/all removes the account's job grants so that it
+// sees every job again
+func userAllJobsHandler(w http.ResponseWriter, r *http.Request) {
+	userAction(w, r, auth.AllowAllJobs)
+}
+
+// userAction runs an admin change to the account named by the {id} path
+// value and goes back to the accounts page
+func userAction(w http.ResponseWriter, r *http.Request, change func(userID int) error) {
+	if !authorize(w, r, models.RoleAdmin, 0) {
+		return
+	}
+
+	userID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := change(userID); err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, auth.ErrUserNotFound) {
+			status = http.StatusNotFound
+		}
+		w.WriteHeader(status)
+		renderUsers(w, r, err.Error(), "")
+		return
+	}
+
+	http.Redirect(w, r, "/users", http.StatusSeeOther)
+}
+
+func renderUsers(w http.ResponseWriter, r *http.Request, errMessage, message string) {
+	users, err := auth.ListUsers()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	jobList, err := db.GetJobsFromDB()
+	if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	jobNames := map[int]string{}
+	for _, j := range jobList {
+		jobNames[j.ID] = j.Name
+	}
+
+	tmpl := template.Must(template.ParseFS(templatesFS,
+		"templates/base.html",
+		"templates/users.html",
+	))
+	if err := tmpl.ExecuteTemplate(w, "base", pageData(r, map[string]interface{}{
+		"ActivePage": "users",
+		"Users":      users,
+		"Jobs":       jobList,
+		"JobNames":   jobNames,
+		"Roles":      models.Roles,
+		"Error":      errMessage,
+		"Message":    message,
+	})); err != nil {
+		log.Printf("Template execution error: %v", err)
+	}
+}
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/abhilashreddysh/croncraft/internal/audit"
+	"github.com/abhilashreddysh/croncraft/internal/db"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+	"github.com/abhilashreddysh/croncraft/internal/utils"
+)
+
+// versionChange compares an earlier version of a job with the version that
+// replaced it, for the history tab of the edit page
+type versionChange struct {
+	Old, New     models.Job
+	ReplacedAt   time.Time
+	CommandDiff  []diffRow
+	OtherChanges []string // other fields that changed, by JSON name
+}
+
+// diffRow is one row of a side-by-side diff. Old or New is empty where a
+// line was only added or only removed.
+type diffRow struct {
+	Old, New         string
+	OldKind, NewKind string // same, removed, added or empty
+}
+
+// shownFields are compared side by side; changes to other fields are only
+// named
+var shownFields = []string{"name", "schedule", "command", "enabled"}
+
+// jobHistory compares every earlier version of the job with the one that
+// replaced it, newest first
+func jobHistory(current *models.Job) ([]versionChange, error) {
+	versions, err := db.GetJobVersions(current.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]versionChange, 0, len(versions))
+	newer := *current
+	for _, v := range versions {
+		c := versionChange{
+			Old:         v.Job,
+			New:         newer,
+			ReplacedAt:  v.ReplacedAt.Local(),
+			CommandDiff: diffLines(v.Job.Command, newer.Command),
+		}
+
+		// Versions do not record whether the job was enabled
+		old := v.Job
+		old.Status = newer.Status
+		changes, err := audit.Diff(&old, &newer)
+		if err != nil {
+			return nil, err
+		}
+		for field := range changes {
+			if !slices.Contains(shownFields, field) {
+				c.OtherChanges = append(c.OtherChanges, field)
+			}
+		}
+		slices.Sort(c.OtherChanges)
+
+		history = append(history, c)
+		newer = v.Job
+	}
+	return history, nil
+}
+
+// diffLines lines up the lines of two texts side by side, pairing removed
+// lines with the lines added in their place
+func diffLines(before, after string) []diffRow {
+	a, b := splitLines(before), splitLines(after)
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var rows []diffRow
+	var removed, added []string
+	flush := func() {
+		for k := 0; k < max(len(removed), len(added)); k++ {
+			var row diffRow
+			if k < len(removed) {
+				row.Old, row.OldKind = removed[k], "removed"
+			}
+			if k < len(added) {
+				row.New, row.NewKind = added[k], "added"
+			}
+			rows = append(rows, row)
+		}
+		removed, added = nil, nil
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			flush()
+			rows = append(rows, diffRow{Old: a[i], New: b[j], OldKind: "same", NewKind: "same"})
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			added = append(added, b[j])
+			j++
+		default:
+			removed = append(removed, a[i])
+			i++
+		}
+	}
+	flush()
+	return rows
+}
+
+// splitLines splits a text into its lines, of which an empty text has none
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+}
+
+// POST /edit/{id}/restore/{version}
+func restoreVersionHandler(w http.ResponseWriter, r *http.Request) {
+	jobID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+		return
+	}
+	version, err := strconv.Atoi(r.PathValue("version"))
+	if err != nil {
+		http.Error(w, "Invalid version", http.StatusBadRequest)
+		return
+	}
+	if !authorize(w, r, models.RoleAdmin, jobID) {
+		return
+	}
+
+	current, err := db.GetJob(jobID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	v, err := db.GetJobVersion(jobID, version)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Restoring brings back the definition but leaves the job enabled or
+	// disabled as it is now. It is saved as a new version.
+	job := v.Job
+	job.Status = current.Status
+	if err := utils.ValidateJob(&job); err != nil {
+		http.Error(w, "Cannot restore version "+strconv.Itoa(version)+": "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := saveJob(r, &job); err != nil {
+		http.Error(w, "Failed to restore job: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/edit/%d#history", jobID), http.StatusSeeOther)
+}
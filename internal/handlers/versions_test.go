@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"slices"
+	"testing"
+)
+
+// diffString writes a row as "old|new", marking removed lines with - and
+// added lines with +
+func diffString(row diffRow) string {
+	mark := map[string]string{"same": "", "removed": "-", "added": "+", "": ""}
+	return mark[row.OldKind] + row.Old + "|" + mark[row.NewKind] + row.New
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after string
+		want          []string
+	}{
+		{"both empty", "", "", nil},
+		{"empty before", "", "a\nb", []string{"|+a", "|+b"}},
+		{"empty after", "a\nb", "", []string{"-a|", "-b|"}},
+		{"unchanged", "a\nb", "a\nb", []string{"a|a", "b|b"}},
+		{"windows line endings", "a\r\nb", "a\nb", []string{"a|a", "b|b"}},
+		{"insertion", "a\nc", "a\nb\nc", []string{"a|a", "|+b", "c|c"}},
+		{"insertions at both ends", "b", "a\nb\nc", []string{"|+a", "b|b", "|+c"}},
+		{"deletion", "a\nb\nc", "a\nc", []string{"a|a", "-b|", "c|c"}},
+		{"deletions at both ends", "a\nb\nc", "b", []string{"-a|", "b|b", "-c|"}},
+		{"changed line", "a\nb\nc", "a\nB\nc", []string{"a|a", "-b|+B", "c|c"}},
+		{"more added than removed", "a\nb", "a\nx\ny", []string{"a|a", "-b|+x", "|+y"}},
+		{"moved line", "a\nb\nc", "b\nc\na", []string{"-a|", "b|b", "c|c", "|+a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, row := range diffLines(tt.before, tt.after) {
+				got = append(got, diffString(row))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("diffLines(%q, %q) = %q, want %q", tt.before, tt.after, got, tt.want)
+			}
+		})
+	}
+}
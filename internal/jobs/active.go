@@ -0,0 +1,92 @@
+package jobs
+
+import (
+	"errors"
+	"log"
+	"os/exec"
+	"sync"
+
+	"github.com/abhilashreddysh/croncraft/internal/models"
+)
+
+// ErrRunNotActive is returned when trying to stop a run that is not in flight
+var ErrRunNotActive = errors.New("run is not active")
+
+// activeRun is an in-flight job run whose process can be stopped
+type activeRun struct {
+	runID int64
+	jobID int
+	cmd   *exec.Cmd
+	done  chan struct{} // closed once the process has been waited for
+
+	mu        sync.Mutex
+	status    string // status to record instead of the exit result, set when stopped
+	stoppedBy string
+}
+
+var (
+	activeRuns   = make(map[int64]*activeRun)
+	activeRunsMu sync.Mutex
+)
+
+func registerRun(runID int64, jobID int, cmd *exec.Cmd) *activeRun {
+	run := &activeRun{runID: runID, jobID: jobID, cmd: cmd, done: make(chan struct{})}
+
+	activeRunsMu.Lock()
+	activeRuns[runID] = run
+	activeRunsMu.Unlock()
+
+	return run
+}
+
+// finish marks the process as exited and removes the run from the registry
+func (a *activeRun) finish() {
+	close(a.done)
+
+	activeRunsMu.Lock()
+	delete(activeRuns, a.runID)
+	activeRunsMu.Unlock()
+}
+
+// stop terminates the run's process group and records why. Only the first
+// call has any effect, so a timeout racing a cancel keeps a single outcome.
+func (a *activeRun) stop(status, by string) bool {
+	a.mu.Lock()
+	if a.status != "" {
+		a.mu.Unlock()
+		return false
+	}
+	a.status = status
+	a.stoppedBy = by
+	a.mu.Unlock()
+
+	go stopProcess(a.cmd, a.done)
+	return true
+}
+
+// outcome returns the status and actor recorded by stop, if any
+func (a *activeRun) outcome() (string, string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.status, a.stoppedBy
+}
+
+// CancelRun stops an in-flight or queued run and records who cancelled it
+func CancelRun(runID int64, by string) error {
+	activeRunsMu.Lock()
+	run, ok := activeRuns[runID]
+	activeRunsMu.Unlock()
+
+	if !ok {
+		if cancelQueuedRun(runID, by) {
+			log.Printf("Queued run %d cancelled by %s", runID, by)
+			return nil
+		}
+		return ErrRunNotActive
+	}
+
+	if run.stop(models.StatusCancelled, by) {
+		log.Printf("Run %d of job %d cancelled by %s", runID, run.jobID, by)
+	}
+	return nil
+}
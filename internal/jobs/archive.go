@@ -0,0 +1,278 @@
+package jobs
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"time"
+
+	"github.com/abhilashreddysh/croncraft/internal/db"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+	"github.com/abhilashreddysh/croncraft/internal/utils"
+)
+
+// CompressLogsAfter is how long after a run finished its log is compressed,
+// 0 to never compress logs
+var CompressLogsAfter = 24 * time.Hour
+
+// compressedLogName returns the name of the gzip-compressed log of a run
+func compressedLogName(runID int64) string {
+	return LogName(runID) + ".gz"
+}
+
+// OpenLog opens the log of a run for reading from offset bytes on,
+// decompressing it if it has been compressed. It returns an error matching
+// fs.ErrNotExist for runs without a log.
+func OpenLog(runID, offset int64) (io.ReadCloser, error) {
+	r, err := Logs.Open(LogName(runID), offset)
+	if !errors.Is(err, fs.ErrNotExist) {
+		return r, err
+	}
+
+	r, err = Logs.Open(compressedLogName(runID), 0)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, zr, offset); err != nil && !errors.Is(err, io.EOF) {
+		r.Close()
+		return nil, err
+	}
+	return &gzipLog{Reader: zr, r: r}, nil
+}
+
+// gzipLog reads a compressed log and closes the stored log with the reader
+type gzipLog struct {
+	*gzip.Reader
+	r io.ReadCloser
+}
+
+func (g *gzipLog) Close() error {
+	g.Reader.Close()
+	return g.r.Close()
+}
+
+// LogSize returns the size of a run's log as stored and whether it is
+// compressed, looking the log up in the log store. It returns an error
+// matching fs.ErrNotExist for runs without a log. The sizes of finished
+// runs' logs are kept in job_runs, which is cheaper to read.
+func LogSize(runID int64) (int64, bool, error) {
+	info, err := Logs.Stat(LogName(runID))
+	if err == nil {
+		return info.Size, false, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return 0, false, err
+	}
+
+	info, err = Logs.Stat(compressedLogName(runID))
+	if err != nil {
+		return 0, false, err
+	}
+	return info.Size, true, nil
+}
+
+// RemoveLog deletes the log of a run, compressed or not
+func RemoveLog(runID int64) error {
+	for _, name := range []string{LogName(runID), compressedLogName(runID)} {
+		if err := Logs.Delete(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CompressOldLogs compresses the logs of runs that finished more than
+// CompressLogsAfter ago. Each run is handled once and marked as archived.
+func CompressOldLogs() {
+	if CompressLogsAfter <= 0 {
+		return
+	}
+
+	type finishedRun struct {
+		id       int64
+		finished time.Time
+	}
+	var runs []finishedRun
+	err := utils.RetryDBOperation(func() error {
+		runs = nil
+		rows, err := db.DB.Query(`
+			SELECT id, run_at, COALESCE(duration_ms, 0) FROM job_runs
+			WHERE log_archived = 0 AND status NOT IN (?, ?)`,
+			models.StatusRunning, models.StatusQueued,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var run finishedRun
+			var runAt string
+			var durationMs int64
+			if err := rows.Scan(&run.id, &runAt, &durationMs); err != nil {
+				return err
+			}
+			start, err := time.Parse(time.RFC3339, runAt)
+			if err != nil {
+				continue
+			}
+			run.finished = start.Add(time.Duration(durationMs) * time.Millisecond)
+			runs = append(runs, run)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		log.Printf("Failed to list logs to compress: %v", err)
+		return
+	}
+
+	var compressed int
+	var saved int64
+	cutoff := time.Now().Add(-CompressLogsAfter)
+	for _, run := range runs {
+		if run.finished.After(cutoff) {
+			continue
+		}
+
+		size, stored, err := compressLog(run.id)
+		if err != nil {
+			log.Printf("Failed to compress log of run %d: %v", run.id, err)
+			continue
+		}
+
+		// Logs left uncompressed are stored at their full size
+		storedSize := size
+		if stored > 0 {
+			storedSize = stored
+		}
+		err = utils.RetryDBOperation(func() error {
+			_, err := db.DB.Exec(`
+				UPDATE job_runs SET log_archived = 1, log_bytes = ?, log_stored_bytes = ?, log_compressed = ?
+				WHERE id = ?`,
+				size, storedSize, stored > 0, run.id)
+			return err
+		})
+		if err != nil {
+			log.Printf("Failed to record compressed log of run %d: %v", run.id, err)
+			continue
+		}
+		if stored > 0 {
+			compressed++
+			saved += size - stored
+		}
+	}
+	if compressed > 0 {
+		log.Printf("Compressed %d run logs, saving %s", compressed, utils.FormatFileSize(saved))
+	}
+}
+
+// MeasureLogs records the size of the logs of finished runs whose size is
+// not known yet, such as runs that finished before sizes were recorded or
+// were interrupted by a crash. Runs without a log are recorded with size 0.
+func MeasureLogs() {
+	var ids []int64
+	err := utils.RetryDBOperation(func() error {
+		ids = nil
+		rows, err := db.DB.Query(`
+			SELECT id FROM job_runs
+			WHERE log_stored_bytes IS NULL AND status NOT IN (?, ?)`,
+			models.StatusRunning, models.StatusQueued,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		log.Printf("Failed to list logs to measure: %v", err)
+		return
+	}
+
+	for _, id := range ids {
+		stored, compressed, err := LogSize(id)
+		if errors.Is(err, fs.ErrNotExist) {
+			stored, compressed = 0, false
+		} else if err != nil {
+			log.Printf("Failed to measure log of run %d: %v", id, err)
+			continue
+		}
+
+		// The uncompressed size of compressed logs was recorded when they
+		// were compressed
+		err = utils.RetryDBOperation(func() error {
+			_, err := db.DB.Exec(`
+				UPDATE job_runs SET log_stored_bytes = ?, log_compressed = ?,
+					log_bytes = CASE WHEN ? THEN COALESCE(log_bytes, ?) ELSE ? END
+				WHERE id = ?`,
+				stored, compressed, compressed, stored, stored, id)
+			return err
+		})
+		if err != nil {
+			log.Printf("Failed to record size of log of run %d: %v", id, err)
+		}
+	}
+}
+
+// compressLog replaces the log of a run with a gzip-compressed copy. It
+// returns the size of the log and of the compressed copy, which is 0 when
+// the run has no log to compress or compressing it would not save space.
+func compressLog(runID int64) (int64, int64, error) {
+	info, err := Logs.Stat(LogName(runID))
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.Size == 0) {
+		return 0, 0, nil
+	} else if err != nil {
+		return 0, 0, err
+	}
+
+	src, err := Logs.Open(LogName(runID), 0)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer src.Close()
+
+	// Until the original is deleted, readers keep reading it rather than a
+	// compressed copy that may be incomplete
+	dst, err := Logs.Create(compressedLogName(runID))
+	if err != nil {
+		return 0, 0, err
+	}
+	zw := gzip.NewWriter(dst)
+	size, err := io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		Logs.Delete(compressedLogName(runID))
+		return 0, 0, err
+	}
+
+	compressed, err := Logs.Stat(compressedLogName(runID))
+	if err != nil {
+		return 0, 0, err
+	}
+	if compressed.Size >= size {
+		return size, 0, Logs.Delete(compressedLogName(runID))
+	}
+	if err := Logs.Delete(LogName(runID)); err != nil {
+		return 0, 0, err
+	}
+	return size, compressed.Size, nil
+}
@@ -0,0 +1,108 @@
+package jobs
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/abhilashreddysh/croncraft/internal/db"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+	"github.com/abhilashreddysh/croncraft/internal/utils"
+)
+
+// CatchUpMissedRuns compares every enabled job's schedule with its last run
+// and starts the runs missed while CronCraft was down, according to the
+// job's misfire policy. Missed runs of a job are run one after another.
+func CatchUpMissedRuns() {
+	jobList, err := db.GetJobsFromDB()
+	if err != nil {
+		log.Printf("Failed to load jobs for catch-up: %v", err)
+		return
+	}
+
+	now := time.Now()
+	for _, j := range jobList {
+		limit := missedRunLimit(j)
+		if !j.Status || limit == 0 {
+			continue
+		}
+
+		since, err := lastScheduledRun(j.ID)
+		if err != nil {
+			log.Printf("Failed to find last run of job %s: %v", j.Name, err)
+			continue
+		}
+
+		missed := countMissedRuns(j, since, now, limit)
+		if missed == 0 {
+			continue
+		}
+
+		log.Printf("Catching up %d missed runs of job %s", missed, j.Name)
+		go func(j models.Job) {
+			for range missed {
+				if _, _, err := Dispatch(j, models.TriggerCatchUp); err != nil {
+					log.Printf("Failed to catch up job %s: %v", j.Name, err)
+					return
+				}
+				waitForJob(j.ID)
+			}
+		}(j)
+	}
+}
+
+// lastScheduledRun returns when the job last ran on schedule, or when it was
+// last saved if that is later, since edits can change the schedule
+func lastScheduledRun(jobID int) (time.Time, error) {
+	var lastRun, updated sql.NullString
+	err := db.DB.QueryRow(`
+		SELECT (SELECT MAX(run_at) FROM job_runs WHERE job_id = j.id AND trigger IN (?, ?)), j.updated_at
+		FROM jobs j WHERE j.id = ?`, models.TriggerSchedule, models.TriggerCatchUp, jobID).Scan(&lastRun, &updated)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var since time.Time
+	for _, v := range []sql.NullString{lastRun, updated} {
+		if !v.Valid {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, v.String); err == nil && t.After(since) {
+			since = t
+		}
+	}
+	return since, nil
+}
+
+// missedRunLimit returns how many missed runs the job's misfire policy
+// catches up with
+func missedRunLimit(j models.Job) int {
+	switch j.MisfirePolicy {
+	case models.MisfireOnce:
+		return 1
+	case models.MisfireAll:
+		return j.MisfireMax
+	default:
+		return 0
+	}
+}
+
+// countMissedRuns counts the firings of the job's schedule after since and up
+// to now, stopping at limit
+func countMissedRuns(j models.Job, since, now time.Time, limit int) int {
+	if since.IsZero() {
+		return 0
+	}
+
+	schedule, err := utils.ParseSchedule(j.Schedule, j.TimeZone)
+	if err != nil {
+		log.Printf("Invalid cron for job %s: %s", j.Name, j.Schedule)
+		return 0
+	}
+
+	missed := 0
+	for t := schedule.Next(since); !t.IsZero() && !t.After(now) && missed < limit; t = schedule.Next(t) {
+		missed++
+	}
+	return missed
+}
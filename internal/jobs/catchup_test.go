@@ -0,0 +1,65 @@
+package jobs
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata" // the test zones must resolve without system zoneinfo
+
+	"github.com/abhilashreddysh/croncraft/internal/models"
+	"github.com/abhilashreddysh/croncraft/internal/utils"
+)
+
+func TestCountMissedRuns(t *testing.T) {
+	oldLocation := utils.ServerLocation
+	utils.ServerLocation = time.UTC
+	t.Cleanup(func() { utils.ServerLocation = oldLocation })
+
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utc := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.January, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name       string
+		schedule   string
+		timeZone   string
+		policy     string
+		misfireMax int
+		since, now time.Time
+		want       int
+	}{
+		{"ignore", "0 * * * *", "", models.MisfireIgnore, 10, utc(1, 0, 30), utc(1, 5, 0), 0},
+		{"empty policy", "0 * * * *", "", "", 10, utc(1, 0, 30), utc(1, 5, 0), 0},
+		{"once", "0 * * * *", "", models.MisfireOnce, 10, utc(1, 0, 30), utc(1, 5, 0), 1},
+		{"all", "0 * * * *", "", models.MisfireAll, 10, utc(1, 0, 30), utc(1, 5, 0), 5},
+		{"all capped", "0 * * * *", "", models.MisfireAll, 3, utc(1, 0, 30), utc(1, 5, 0), 3},
+		{"firing at now counts", "0 * * * *", "", models.MisfireAll, 10, utc(1, 0, 30), utc(1, 2, 0), 2},
+		{"firing at since does not", "0 * * * *", "", models.MisfireAll, 10, utc(1, 1, 0), utc(1, 2, 30), 1},
+		{"nothing missed", "0 * * * *", "", models.MisfireAll, 10, utc(1, 1, 10), utc(1, 1, 50), 0},
+		{"never ran", "0 * * * *", "", models.MisfireAll, 10, time.Time{}, utc(1, 5, 0), 0},
+		{"invalid schedule", "not a schedule", "", models.MisfireAll, 10, utc(1, 0, 30), utc(1, 5, 0), 0},
+		// 09:00 in Kolkata is 03:30 UTC, so only the job's zone sees a
+		// third firing before 04:00 UTC on the 3rd
+		{"job time zone", "0 9 * * *", "Asia/Kolkata", models.MisfireAll, 10, utc(1, 3, 0), utc(3, 4, 0), 3},
+		{"server time zone", "0 9 * * *", "", models.MisfireAll, 10, utc(1, 3, 0), utc(3, 4, 0), 2},
+		{"since in another zone", "0 9 * * *", "Asia/Kolkata", models.MisfireAll, 10, utc(1, 3, 0).In(newYork), utc(3, 4, 0), 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j := models.Job{
+				Name:          "test",
+				Schedule:      tt.schedule,
+				TimeZone:      tt.timeZone,
+				MisfirePolicy: tt.policy,
+				MisfireMax:    tt.misfireMax,
+			}
+			if got := countMissedRuns(j, tt.since, tt.now, missedRunLimit(j)); got != tt.want {
+				t.Errorf("countMissedRuns = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,21 +1,25 @@
 package jobs
 
 import (
-	"bufio"
+	"database/sql"
 	"fmt"
 	"io"
 	"log"
-	"os"
 	"os/exec"
 	"sync"
 	"time"
 
 	"github.com/abhilashreddysh/croncraft/internal/db"
+	"github.com/abhilashreddysh/croncraft/internal/logstore"
 	"github.com/abhilashreddysh/croncraft/internal/models"
 	"github.com/abhilashreddysh/croncraft/internal/utils"
 	"github.com/robfig/cron/v3"
 )
 
+// killGracePeriod is how long a stopped job gets to exit after SIGTERM
+// before the whole process group is killed.
+const killGracePeriod = 10 * time.Second
+
 var (
 	C       *cron.Cron
 	CronMap map[int]cron.EntryID
@@ -23,8 +27,25 @@ var (
 	DbMu    sync.Mutex   // For serializing database write operations
 )
 
+var (
+	// Logs stores the full logs of runs
+	Logs logstore.Store = logstore.NewFS("logs")
+
+	// LogDir is the local directory run logs are written to, or spooled in
+	// until they are uploaded when Logs is remote
+	LogDir = "logs"
+
+	// Shell runs job commands as Shell -c command
+	Shell = "sh"
+)
+
+// LogName returns the name of the log of a run in Logs
+func LogName(runID int64) string {
+	return fmt.Sprintf("%d.log", runID)
+}
+
 func InitializeCron() {
-	C = cron.New()
+	C = cron.New(cron.WithLocation(utils.ServerLocation))
 	CronMap = make(map[int]cron.EntryID)
 	C.Start()
 }
@@ -48,8 +69,10 @@ func RegisterCron(j models.Job) {
 		return
 	}
 
-	id, err := C.AddFunc(j.Schedule, func() {
-		RunJob(j.ID, j.Name, j.Command)
+	id, err := C.AddFunc(utils.CronSpec(j.Schedule, j.TimeZone), func() {
+		if _, _, err := Dispatch(j, models.TriggerSchedule); err != nil {
+			log.Printf("Failed to dispatch job %s: %v", j.Name, err)
+		}
 	})
 
 	if err != nil {
@@ -62,69 +85,77 @@ func RegisterCron(j models.Job) {
 	Mu.Unlock()
 }
 
-func RunJob(jobID int, name, command string) {
+// RunJob executes j for the job_runs row runRowID created by Dispatch and
+// records the outcome on that row. It returns the final status and the exit
+// code of the command, or -1 if it did not exit normally.
+func RunJob(j models.Job, runRowID int64) (string, int) {
+    jobID, name := j.ID, j.Name
     runAt := time.Now().Format(time.RFC3339)
     const maxDBOutput = 500 * 1024       // 500 KB preview in DB
     const batchInterval = 2 * time.Second
 
     startTime := time.Now() // track duration
 
-    var runRowID int64
+    // Queued runs start now, so their run time moves to the actual start
     err := utils.RetryDBOperation(func() error {
-        res, err := db.DB.Exec(
-            "INSERT INTO job_runs (job_id, run_at, status, output) VALUES (?, ?, ?, ?)",
-            jobID, runAt, "running", "",
+        _, err := db.DB.Exec(
+            "UPDATE job_runs SET status = ?, run_at = ? WHERE id = ? AND status = ?",
+            models.StatusRunning, runAt, runRowID, models.StatusQueued,
         )
-        if err != nil {
-            return err
-        }
-        runRowID, err = res.LastInsertId()
         return err
     })
     if err != nil {
-        log.Printf("[%s] Failed to insert running job %s: %v", runAt, name, err)
-        return
+        log.Printf("[%s] Failed to start queued run of job %s: %v", runAt, name, err)
     }
 
     log.Printf("[%s] Running job: %s", runAt, name)
 
-    // Create log directory & file
-    logDir := "./logs"
-    if err := os.MkdirAll(logDir, 0755); err != nil {
-        log.Printf("[%s] Failed to create log directory for job %s: %v", runAt, name, err)
-        return
-    }
-    logFilePath := fmt.Sprintf("%s/%d.log", logDir, runRowID)
-    f, err := os.Create(logFilePath)
+    // Create the log, which is saved once closed
+    f, err := Logs.Create(LogName(runRowID))
     if err != nil {
-        log.Printf("[%s] Failed to create log file for job %s: %v", runAt, name, err)
-        return
+        log.Printf("[%s] Failed to create log for job %s: %v", runAt, name, err)
+        failRun(runRowID, "Failed to create log: "+err.Error())
+        return models.StatusFailed, -1
     }
-    defer f.Close()
+    defer func() {
+        if err := f.Close(); err != nil {
+            log.Printf("[%s] Failed to save log of job %s: %v", runAt, name, err)
+        }
+    }()
 
     // Start command
-    cmd := exec.Command("sh", "-c", command)
+    cmd := exec.Command(Shell, "-c", j.Command)
+    setProcessGroup(cmd)
     stdoutPipe, _ := cmd.StdoutPipe()
     stderrPipe, _ := cmd.StderrPipe()
-    reader := io.MultiReader(stdoutPipe, stderrPipe)
 
     if err := cmd.Start(); err != nil {
         log.Printf("[%s] Failed to start job %s: %v", runAt, name, err)
-        return
+        failRun(runRowID, "Failed to start command: "+err.Error())
+        return models.StatusFailed, -1
     }
 
-    // Capture output for DB and file
-    scanner := bufio.NewScanner(reader)
-    buf := make([]byte, 0, 1024*1024)
-    scanner.Buffer(buf, 10*1024*1024)
+    // Track the run so it can be cancelled, and enforce the job timeout
+    run := registerRun(runRowID, jobID, cmd)
+    if j.TimeoutSeconds > 0 {
+        timer := time.AfterFunc(time.Duration(j.TimeoutSeconds)*time.Second, func() {
+            if run.stop(models.StatusTimeout, "") {
+                log.Printf("[%s] Job %s timed out after %ds", runAt, name, j.TimeoutSeconds)
+            }
+        })
+        defer timer.Stop()
+    }
 
+    // Capture output for DB and file, keeping stdout and stderr interleaved
     var outputDB string
+    var logBytes int64
     truncated := false
     lastUpdate := time.Now()
 
-    for scanner.Scan() {
-        line := scanner.Text() + "\n"
-        f.WriteString(line) // always write to file
+    for out := range captureOutput(stdoutPipe, stderrPipe) {
+        n, _ := io.WriteString(f, FormatLogLine(out.stream, out.text)) // always write to the log
+        logBytes += int64(n)
+        line := out.text + "\n"
 
         // Keep preview for DB
         if len(outputDB) < maxDBOutput {
@@ -154,9 +185,13 @@ func RunJob(jobID int, name, command string) {
     }
 
     err = cmd.Wait()
-    status := "success"
-    if err != nil {
-        status = "failed"
+    run.finish()
+    status := models.StatusSuccess
+    stoppedStatus, stoppedBy := run.outcome()
+    if stoppedStatus != "" {
+        status = stoppedStatus
+    } else if err != nil {
+        status = models.StatusFailed
         log.Printf("[%s] Job %s failed: %v", runAt, name, err)
     }
 
@@ -166,16 +201,48 @@ func RunJob(jobID int, name, command string) {
     if truncated {
         finalOutput += "... (truncated)\n"
     }
+    exitCode := cmd.ProcessState.ExitCode()
+    signal := exitSignal(cmd.ProcessState)
     _ = utils.RetryDBOperation(func() error {
         _, err := db.DB.Exec(
-            "UPDATE job_runs SET status = ?, duration_ms = ?, output = ? WHERE id = ?",
-            status, duration.Milliseconds(), finalOutput, runRowID,
+            `UPDATE job_runs SET status = ?, duration_ms = ?, output = ?, cancelled_by = ?,
+                exit_code = ?, signal = ?, log_bytes = ?, log_stored_bytes = ?
+            WHERE id = ?`,
+            status, duration.Milliseconds(), finalOutput, sql.NullString{String: stoppedBy, Valid: stoppedBy != ""},
+            sql.NullInt64{Int64: int64(exitCode), Valid: exitCode >= 0}, sql.NullString{String: signal, Valid: signal != ""},
+            logBytes, logBytes, runRowID,
         )
         return err
     })
+    notifyRunFinished(runRowID)
 
-    // Optional: prune old logs
-    _ = utils.RetryDBOperation(func() error {
-        return pruneLogs(jobID)
-    })
+    return status, exitCode
+}
+
+// failRun records a run that could not be started as failed
+func failRun(runRowID int64, message string) {
+	_ = utils.RetryDBOperation(func() error {
+		_, err := db.DB.Exec(
+			"UPDATE job_runs SET status = ?, duration_ms = 0, output = ?, log_bytes = 0, log_stored_bytes = 0 WHERE id = ?",
+			models.StatusFailed, message+"\n", runRowID,
+		)
+		return err
+	})
+	notifyRunFinished(runRowID)
+}
+
+// stopProcess sends SIGTERM to the job's process group and escalates to
+// SIGKILL if it has not exited (done closed) within killGracePeriod.
+func stopProcess(cmd *exec.Cmd, done <-chan struct{}) {
+	if err := terminateProcessGroup(cmd); err != nil {
+		log.Printf("Failed to terminate process group: %v", err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(killGracePeriod):
+		if err := killProcessGroup(cmd); err != nil {
+			log.Printf("Failed to kill process group: %v", err)
+		}
+	}
 }
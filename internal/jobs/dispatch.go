@@ -0,0 +1,366 @@
+package jobs
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/abhilashreddysh/croncraft/internal/db"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+	"github.com/abhilashreddysh/croncraft/internal/utils"
+)
+
+// jobSlot tracks the in-flight and waiting runs of a single job so its
+// overlap policy can be applied to both scheduled and manual triggers
+type jobSlot struct {
+	running     int
+	idle        chan struct{} // closed when running drops to zero
+	queued      models.Job
+	queuedRunID int64
+	queuedBy    string // trigger of the queued run
+	queuing     bool   // a queued run is being recorded
+	replacing   *replacement
+}
+
+// replacement is a run started by the replace policy that waits for the
+// runs it replaces to exit. It is counted in running while it waits.
+type replacement struct {
+	runID      int64         // zero while the run is being recorded
+	superseded chan struct{} // closed when it is cancelled or replaced itself
+}
+
+// start counts a new in-flight run. Callers hold slotsMu.
+func (s *jobSlot) start() {
+	if s.running == 0 {
+		s.idle = make(chan struct{})
+	}
+	s.running++
+}
+
+// finish counts a run as done. Callers hold slotsMu.
+func (s *jobSlot) finish() {
+	s.running--
+	if s.running == 0 {
+		close(s.idle)
+	}
+}
+
+// supersede drops the waiting replacement, if any, and returns its run id,
+// or zero if there is none or it has not been recorded yet. Callers hold
+// slotsMu.
+func (s *jobSlot) supersede() int64 {
+	r := s.replacing
+	if r == nil {
+		return 0
+	}
+	close(r.superseded)
+	s.replacing = nil
+	return r.runID
+}
+
+var (
+	slots   = make(map[int]*jobSlot)
+	slotsMu sync.Mutex
+)
+
+// Dispatch starts a run of j according to its overlap policy, recording what
+// triggered it. It returns the id of the job_runs row recording the run and
+// the status it was given. The slot is reserved under slotsMu and the row
+// inserted after releasing it, so a busy database does not hold up other
+// jobs.
+func Dispatch(j models.Job, trigger string) (int64, string, error) {
+	// Checked under slotsMu, which Shutdown sets the flag under, so that no
+	// run is added to inFlight once Shutdown may be waiting on it
+	slotsMu.Lock()
+	if shuttingDown.Load() {
+		slotsMu.Unlock()
+		return 0, "", ErrShuttingDown
+	}
+
+	slot, ok := slots[j.ID]
+	if !ok {
+		slot = &jobSlot{}
+		slots[j.ID] = slot
+	}
+
+	if slot.running > 0 {
+		switch j.Concurrency {
+		case models.ConcurrencySkip:
+			slotsMu.Unlock()
+			return skipRun(j, trigger, "previous run still in progress")
+
+		case models.ConcurrencyQueue:
+			if slot.queuedRunID != 0 || slot.queuing {
+				slotsMu.Unlock()
+				return skipRun(j, trigger, "a run is already queued")
+			}
+			slot.queuing = true
+			slotsMu.Unlock()
+			return queueRun(j, slot, trigger)
+
+		case models.ConcurrencyReplace:
+			cancelled := takeJobRetries(j.ID)
+			if runID := slot.supersede(); runID != 0 {
+				cancelled = append(cancelled, runID)
+			}
+			previous := stopJobRuns(j.ID, "overlap policy")
+			r := &replacement{superseded: make(chan struct{})}
+			slot.replacing = r
+			slot.start()
+			inFlight.Add(1)
+			slotsMu.Unlock()
+
+			for _, runID := range cancelled {
+				markCancelled(runID, "overlap policy")
+			}
+			return replaceRuns(j, slot, r, previous, trigger)
+		}
+	}
+
+	slot.start()
+	inFlight.Add(1)
+	slotsMu.Unlock()
+
+	runID, err := insertRun(j, models.StatusRunning, trigger, "")
+	if err != nil {
+		slotsMu.Lock()
+		slot.finish()
+		slotsMu.Unlock()
+		inFlight.Done()
+		return 0, "", err
+	}
+
+	go execute(j, runID, trigger)
+	return runID, models.StatusRunning, nil
+}
+
+// queueRun records a run of j to start once its running instance finishes,
+// or right away if it finished while the row was being inserted
+func queueRun(j models.Job, slot *jobSlot, trigger string) (int64, string, error) {
+	runID, err := insertRun(j, models.StatusQueued, trigger, "")
+
+	slotsMu.Lock()
+	slot.queuing = false
+	if err != nil {
+		slotsMu.Unlock()
+		return 0, "", err
+	}
+
+	if slot.running > 0 {
+		slot.queued = j
+		slot.queuedRunID = runID
+		slot.queuedBy = trigger
+		slotsMu.Unlock()
+		log.Printf("Queued job %s behind its running instance", j.Name)
+		return runID, models.StatusQueued, nil
+	}
+
+	if shuttingDown.Load() {
+		slotsMu.Unlock()
+		interruptRun(runID)
+		return runID, models.StatusInterrupted, nil
+	}
+	slot.start()
+	inFlight.Add(1)
+	slotsMu.Unlock()
+
+	go execute(j, runID, trigger)
+	return runID, models.StatusQueued, nil
+}
+
+// replaceRuns records the replacement r reserved by Dispatch and starts it
+// once the previous runs have exited, unless it is superseded first
+func replaceRuns(j models.Job, slot *jobSlot, r *replacement, previous []*activeRun, trigger string) (int64, string, error) {
+	runID, err := insertRun(j, models.StatusQueued, trigger, "")
+
+	slotsMu.Lock()
+	current := slot.replacing == r
+	if err != nil || !current {
+		if current {
+			slot.replacing = nil
+		}
+		slot.finish()
+		slotsMu.Unlock()
+		inFlight.Done()
+
+		if err != nil {
+			return 0, "", err
+		}
+		markCancelled(runID, "overlap policy")
+		return runID, models.StatusCancelled, nil
+	}
+	r.runID = runID
+	slotsMu.Unlock()
+
+	go func() {
+		for _, run := range previous {
+			select {
+			case <-run.done:
+			case <-r.superseded:
+			}
+		}
+
+		slotsMu.Lock()
+		if slot.replacing != r {
+			slot.finish()
+			slotsMu.Unlock()
+			inFlight.Done()
+			return
+		}
+		slot.replacing = nil
+		slotsMu.Unlock()
+
+		execute(j, runID, trigger)
+	}()
+	return runID, models.StatusQueued, nil
+}
+
+// execute runs the job, including any retries, and then starts the queued
+// run, if any. Runs reaching it after Shutdown started are not started.
+func execute(j models.Job, runID int64, trigger string) {
+	defer inFlight.Done()
+
+	if shuttingDown.Load() {
+		interruptRun(runID)
+	} else {
+		runWithRetries(j, runID, trigger)
+	}
+
+	slotsMu.Lock()
+	slot := slots[j.ID]
+	if slot.running > 1 || slot.queuedRunID == 0 {
+		slot.finish()
+		slotsMu.Unlock()
+		return
+	}
+
+	// Hand this run's place over to the queued run
+	next, nextRunID, nextTrigger := slot.queued, slot.queuedRunID, slot.queuedBy
+	slot.queued, slot.queuedRunID, slot.queuedBy = models.Job{}, 0, ""
+	inFlight.Add(1)
+	slotsMu.Unlock()
+
+	go execute(next, nextRunID, nextTrigger)
+}
+
+// waitForJob blocks until no run of the job is in flight or queued
+func waitForJob(jobID int) {
+	slotsMu.Lock()
+	slot, ok := slots[jobID]
+	if !ok || slot.running == 0 {
+		slotsMu.Unlock()
+		return
+	}
+	idle := slot.idle
+	slotsMu.Unlock()
+
+	<-idle
+}
+
+// cancelQueuedRun drops a run that is waiting for its job to become free,
+// for the runs it replaces to exit or for its retry delay to pass
+func cancelQueuedRun(runID int64, by string) bool {
+	slotsMu.Lock()
+	found := false
+	if retry, ok := pendingRetries[runID]; ok {
+		close(retry.cancelled)
+		delete(pendingRetries, runID)
+		found = true
+	}
+
+	for _, slot := range slots {
+		if slot.queuedRunID == runID {
+			slot.queued, slot.queuedRunID, slot.queuedBy = models.Job{}, 0, ""
+			found = true
+		}
+		if slot.replacing != nil && slot.replacing.runID == runID {
+			slot.supersede()
+			found = true
+		}
+	}
+	slotsMu.Unlock()
+
+	if !found {
+		return false
+	}
+
+	markCancelled(runID, by)
+	return true
+}
+
+// takeJobRetries cancels the attempts of a job waiting out their backoff and
+// returns their run ids, to be recorded as cancelled once slotsMu is
+// released. The caller holds slotsMu.
+func takeJobRetries(jobID int) []int64 {
+	var runIDs []int64
+	for runID, retry := range pendingRetries {
+		if retry.jobID != jobID {
+			continue
+		}
+		close(retry.cancelled)
+		delete(pendingRetries, runID)
+		runIDs = append(runIDs, runID)
+		log.Printf("Pending retry %d of job %d cancelled by overlap policy", runID, jobID)
+	}
+	return runIDs
+}
+
+// markCancelled records a run that never started as cancelled
+func markCancelled(runID int64, by string) {
+	_ = utils.RetryDBOperation(func() error {
+		_, err := db.DB.Exec(
+			"UPDATE job_runs SET status = ?, duration_ms = 0, cancelled_by = ?, log_bytes = 0, log_stored_bytes = 0 WHERE id = ?",
+			models.StatusCancelled, by, runID,
+		)
+		return err
+	})
+	notifyRunFinished(runID)
+}
+
+// stopJobRuns cancels every active run of a job and returns them
+func stopJobRuns(jobID int, reason string) []*activeRun {
+	activeRunsMu.Lock()
+	var runs []*activeRun
+	for _, run := range activeRuns {
+		if run.jobID == jobID {
+			runs = append(runs, run)
+		}
+	}
+	activeRunsMu.Unlock()
+
+	for _, run := range runs {
+		run.stop(models.StatusCancelled, reason)
+	}
+	return runs
+}
+
+// skipRun records a trigger that was not run because of the overlap policy
+func skipRun(j models.Job, trigger, reason string) (int64, string, error) {
+	log.Printf("Skipping run of job %s: %s", j.Name, reason)
+	runID, err := insertRun(j, models.StatusSkipped, trigger, fmt.Sprintf("Skipped: %s\n", reason))
+	return runID, models.StatusSkipped, err
+}
+
+// insertRun creates the job_runs row for a new run of the job's current
+// version
+func insertRun(j models.Job, status, trigger, output string) (int64, error) {
+	runAt := time.Now().Format(time.RFC3339)
+
+	var runID int64
+	err := utils.RetryDBOperation(func() error {
+		res, err := db.DB.Exec(
+			"INSERT INTO job_runs (job_id, run_at, status, trigger, output, job_version) VALUES (?, ?, ?, ?, ?, ?)",
+			j.ID, runAt, status, trigger, output, j.Version,
+		)
+		if err != nil {
+			return err
+		}
+		runID, err = res.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert run: %w", err)
+	}
+	return runID, nil
+}
@@ -0,0 +1,245 @@
+package jobs
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/abhilashreddysh/croncraft/internal/db"
+	"github.com/abhilashreddysh/croncraft/internal/logstore"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+)
+
+// lingeringCommand ignores SIGTERM, so a stopped run keeps going until it
+// exits by itself and its replacement has to wait for it
+const lingeringCommand = "trap '' TERM; sleep 1"
+
+// setupDispatch opens a fresh database and log directory and forgets the
+// slots of previous tests
+func setupDispatch(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	if err := db.InitializeDatabase(filepath.Join(dir, "croncraft.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.DB.Close() })
+
+	oldLogs := Logs
+	Logs = logstore.NewFS(filepath.Join(dir, "logs"))
+	t.Cleanup(func() { Logs = oldLogs })
+
+	slotsMu.Lock()
+	slots = make(map[int]*jobSlot)
+	slotsMu.Unlock()
+}
+
+// createJob stores a job running command under the given overlap policy
+func createJob(t *testing.T, command, policy string) models.Job {
+	t.Helper()
+	j := models.Job{
+		Name:        "test",
+		Schedule:    "@every 1h",
+		Command:     command,
+		Status:      true,
+		Concurrency: policy,
+		MaxAttempts: 1,
+		Version:     1,
+	}
+	res, err := db.DB.Exec(
+		"INSERT INTO jobs (name, schedule, command, status, concurrency_policy) VALUES (?, ?, ?, ?, ?)",
+		j.Name, j.Schedule, j.Command, "1", j.Concurrency,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	j.ID = int(id)
+	t.Cleanup(func() { waitForJob(j.ID) })
+	return j
+}
+
+func dispatch(t *testing.T, j models.Job) (int64, string) {
+	t.Helper()
+	runID, status, err := Dispatch(j, models.TriggerManual)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return runID, status
+}
+
+// waitUntil polls cond until it holds, failing the test after a few seconds
+func waitUntil(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func waitActive(t *testing.T, runID int64) {
+	t.Helper()
+	waitUntil(t, "run to start", func() bool {
+		activeRunsMu.Lock()
+		defer activeRunsMu.Unlock()
+		return activeRuns[runID] != nil
+	})
+}
+
+func runStatus(t *testing.T, runID int64) string {
+	t.Helper()
+	var status string
+	if err := db.DB.QueryRow("SELECT status FROM job_runs WHERE id = ?", runID).Scan(&status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestDispatchOverlapPolicies(t *testing.T) {
+	tests := []struct {
+		policy      string
+		dispatched  string // status given to the second trigger
+		cancelErr   error  // CancelRun of the second run while the first runs
+		first       string // final statuses
+		second      string
+		secondStart bool // the second run starts alongside the first
+	}{
+		{models.ConcurrencyAllow, models.StatusRunning, nil, models.StatusSuccess, models.StatusCancelled, true},
+		{models.ConcurrencySkip, models.StatusSkipped, ErrRunNotActive, models.StatusSuccess, models.StatusSkipped, false},
+		{models.ConcurrencyQueue, models.StatusQueued, nil, models.StatusSuccess, models.StatusCancelled, false},
+		{models.ConcurrencyReplace, models.StatusQueued, nil, models.StatusCancelled, models.StatusCancelled, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			setupDispatch(t)
+			j := createJob(t, lingeringCommand, tt.policy)
+
+			first, status := dispatch(t, j)
+			if status != models.StatusRunning {
+				t.Fatalf("first run dispatched as %s", status)
+			}
+			waitActive(t, first)
+
+			second, status := dispatch(t, j)
+			if status != tt.dispatched {
+				t.Errorf("second run dispatched as %s, want %s", status, tt.dispatched)
+			}
+			if tt.secondStart {
+				waitActive(t, second)
+			}
+
+			if err := CancelRun(second, "tester"); !errors.Is(err, tt.cancelErr) {
+				t.Errorf("CancelRun(second) = %v, want %v", err, tt.cancelErr)
+			}
+
+			waitForJob(j.ID)
+			if got := runStatus(t, first); got != tt.first {
+				t.Errorf("first run %s, want %s", got, tt.first)
+			}
+			if got := runStatus(t, second); got != tt.second {
+				t.Errorf("second run %s, want %s", got, tt.second)
+			}
+		})
+	}
+}
+
+func TestDispatchQueueHoldsOneRun(t *testing.T) {
+	setupDispatch(t)
+	j := createJob(t, "sleep 0.3", models.ConcurrencyQueue)
+
+	first, _ := dispatch(t, j)
+	queued, _ := dispatch(t, j)
+	extra, status := dispatch(t, j)
+	if status != models.StatusSkipped {
+		t.Errorf("third run dispatched as %s, want %s", status, models.StatusSkipped)
+	}
+
+	waitForJob(j.ID)
+	for _, run := range []struct {
+		id   int64
+		want string
+	}{{first, models.StatusSuccess}, {queued, models.StatusSuccess}, {extra, models.StatusSkipped}} {
+		if got := runStatus(t, run.id); got != run.want {
+			t.Errorf("run %d %s, want %s", run.id, got, run.want)
+		}
+	}
+}
+
+func TestDispatchDoubleReplace(t *testing.T) {
+	setupDispatch(t)
+	j := createJob(t, lingeringCommand, models.ConcurrencyReplace)
+
+	first, _ := dispatch(t, j)
+	waitActive(t, first)
+
+	replaced, _ := dispatch(t, j)
+	replacement, status := dispatch(t, j)
+	if status != models.StatusQueued {
+		t.Errorf("second replacement dispatched as %s", status)
+	}
+
+	// The superseded replacement never starts and cannot be cancelled again
+	if got := runStatus(t, replaced); got != models.StatusCancelled {
+		t.Errorf("superseded replacement %s, want %s", got, models.StatusCancelled)
+	}
+	if err := CancelRun(replaced, "tester"); !errors.Is(err, ErrRunNotActive) {
+		t.Errorf("CancelRun(superseded) = %v, want %v", err, ErrRunNotActive)
+	}
+
+	waitActive(t, replacement)
+	activeRunsMu.Lock()
+	if n := len(activeRuns); n != 1 {
+		t.Errorf("%d runs active, want only the replacement", n)
+	}
+	activeRunsMu.Unlock()
+
+	waitForJob(j.ID)
+	for _, run := range []struct {
+		id   int64
+		want string
+	}{{first, models.StatusCancelled}, {replaced, models.StatusCancelled}, {replacement, models.StatusSuccess}} {
+		if got := runStatus(t, run.id); got != run.want {
+			t.Errorf("run %d %s, want %s", run.id, got, run.want)
+		}
+	}
+}
+
+func TestDispatchReplaceCancelsPendingRetry(t *testing.T) {
+	setupDispatch(t)
+	j := createJob(t, "exit 1", models.ConcurrencyReplace)
+	j.MaxAttempts = 2
+	j.RetryDelaySeconds = 60
+
+	first, _ := dispatch(t, j)
+
+	var retry int64
+	waitUntil(t, "retry to be queued", func() bool {
+		slotsMu.Lock()
+		defer slotsMu.Unlock()
+		for runID := range pendingRetries {
+			retry = runID
+			return true
+		}
+		return false
+	})
+
+	j.MaxAttempts = 1
+	replacement, _ := dispatch(t, j)
+
+	waitForJob(j.ID)
+	for _, run := range []struct {
+		id   int64
+		want string
+	}{{first, models.StatusFailed}, {retry, models.StatusCancelled}, {replacement, models.StatusFailed}} {
+		if got := runStatus(t, run.id); got != run.want {
+			t.Errorf("run %d %s, want %s", run.id, got, run.want)
+		}
+	}
+	if err := CancelRun(retry, "tester"); !errors.Is(err, ErrRunNotActive) {
+		t.Errorf("CancelRun(retry) = %v, want %v", err, ErrRunNotActive)
+	}
+}
@@ -0,0 +1,70 @@
+package jobs
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/abhilashreddysh/croncraft/internal/db"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+)
+
+// Viewers following a run's log wait on a channel per run that is closed
+// once the run's final status has been recorded
+var (
+	runWatchers   = make(map[int64]map[chan struct{}]bool)
+	runWatchersMu sync.Mutex
+)
+
+// WatchRun returns a channel that is closed once the run's final status is
+// recorded, and a function to stop watching. Runs that finished before the
+// call are not signalled, so callers check the status after watching.
+func WatchRun(runID int64) (<-chan struct{}, func()) {
+	ch := make(chan struct{})
+
+	runWatchersMu.Lock()
+	if runWatchers[runID] == nil {
+		runWatchers[runID] = make(map[chan struct{}]bool)
+	}
+	runWatchers[runID][ch] = true
+	runWatchersMu.Unlock()
+
+	return ch, func() {
+		runWatchersMu.Lock()
+		defer runWatchersMu.Unlock()
+		if runWatchers[runID][ch] {
+			delete(runWatchers[runID], ch)
+			if len(runWatchers[runID]) == 0 {
+				delete(runWatchers, runID)
+			}
+		}
+	}
+}
+
+// notifyRunFinished wakes up everyone watching the run, and the search
+// indexer
+func notifyRunFinished(runID int64) {
+	requestIndexing()
+
+	runWatchersMu.Lock()
+	defer runWatchersMu.Unlock()
+	for ch := range runWatchers[runID] {
+		close(ch)
+	}
+	delete(runWatchers, runID)
+}
+
+// LogInUse reports whether the log file with the given name belongs to a
+// run that has not finished, which may still write to it
+func LogInUse(name string) bool {
+	runID, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(name), ".log"), 10, 64)
+	if err != nil {
+		return false
+	}
+	var status string
+	if err := db.DB.QueryRow("SELECT status FROM job_runs WHERE id = ?", runID).Scan(&status); err != nil {
+		return false
+	}
+	return status == models.StatusRunning || status == models.StatusQueued
+}
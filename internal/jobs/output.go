@@ -0,0 +1,95 @@
+package jobs
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"strings"
+	"sync"
+)
+
+// Output streams of a job's command
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+// Log files hold one line of output per line, prefixed with a tag naming the
+// stream it was written to, so viewers can interleave or filter the streams.
+const (
+	stdoutTag = "o "
+	stderrTag = "e "
+)
+
+// maxLineSize is the longest output line captured in one piece
+const maxLineSize = 10 * 1024 * 1024
+
+// outputLine is a single line of command output
+type outputLine struct {
+	stream string
+	text   string
+}
+
+// FormatLogLine returns the log file representation of an output line,
+// including the trailing newline
+func FormatLogLine(stream, text string) string {
+	if stream == StreamStderr {
+		return stderrTag + text + "\n"
+	}
+	return stdoutTag + text + "\n"
+}
+
+// ParseLogLine splits a log file line, without its newline, into its stream
+// and text. Lines from logs written before streams were tagged are reported
+// as stdout.
+func ParseLogLine(raw string) (string, string) {
+	if text, ok := strings.CutPrefix(raw, stderrTag); ok {
+		return StreamStderr, text
+	}
+	if text, ok := strings.CutPrefix(raw, stdoutTag); ok {
+		return StreamStdout, text
+	}
+	return StreamStdout, raw
+}
+
+// NewLogScanner returns a line scanner for log files that accepts the same
+// line lengths the job runner captures
+func NewLogScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize+len(stdoutTag))
+	return scanner
+}
+
+// captureOutput reads stdout and stderr concurrently and delivers their lines
+// on one channel in the order they arrive. The channel is closed once both
+// streams are drained.
+func captureOutput(stdout, stderr io.Reader) <-chan outputLine {
+	lines := make(chan outputLine, 64)
+
+	var wg sync.WaitGroup
+	read := func(stream string, r io.Reader) {
+		defer wg.Done()
+
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+		for scanner.Scan() {
+			lines <- outputLine{stream: stream, text: scanner.Text()}
+		}
+		if err := scanner.Err(); err != nil {
+			log.Printf("Failed to read %s: %v", stream, err)
+			// Drain the pipe so the process is not blocked writing to it
+			_, _ = io.Copy(io.Discard, r)
+		}
+	}
+
+	wg.Add(2)
+	go read(StreamStdout, stdout)
+	go read(StreamStderr, stderr)
+
+	go func() {
+		wg.Wait()
+		close(lines)
+	}()
+
+	return lines
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package jobs
+
+import (
+	"os"
+	"os/exec"
+)
+
+// Windows has no process groups we can signal, so only the direct child
+// process is stopped.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	return terminateProcessGroup(cmd)
+}
+
+func exitSignal(state *os.ProcessState) string {
+	return ""
+}
@@ -0,0 +1,103 @@
+package jobs
+
+import (
+	"log"
+	"time"
+
+	"github.com/abhilashreddysh/croncraft/internal/db"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+	"github.com/abhilashreddysh/croncraft/internal/utils"
+)
+
+// RecoverInterruptedRuns marks runs left running or queued by a previous
+// CronCraft process as interrupted, then reruns the jobs that ask for it.
+// It must be called before any job is dispatched.
+func RecoverInterruptedRuns() {
+	type staleRun struct {
+		id    int64
+		jobID int
+		runAt string
+	}
+
+	var stale []staleRun
+	err := utils.RetryDBOperation(func() error {
+		stale = nil
+		rows, err := db.DB.Query(
+			"SELECT id, job_id, run_at FROM job_runs WHERE status IN (?, ?)",
+			models.StatusRunning, models.StatusQueued,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var r staleRun
+			if err := rows.Scan(&r.id, &r.jobID, &r.runAt); err != nil {
+				return err
+			}
+			stale = append(stale, r)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		log.Printf("Failed to look up interrupted runs: %v", err)
+		return
+	}
+
+	interrupted := make(map[int]bool)
+	for _, r := range stale {
+		duration := interruptedDuration(r.id, r.runAt)
+		err := utils.RetryDBOperation(func() error {
+			_, err := db.DB.Exec(
+				"UPDATE job_runs SET status = ?, duration_ms = ? WHERE id = ?",
+				models.StatusInterrupted, duration.Milliseconds(), r.id,
+			)
+			return err
+		})
+		if err != nil {
+			log.Printf("Failed to mark run %d as interrupted: %v", r.id, err)
+			continue
+		}
+		interrupted[r.jobID] = true
+	}
+
+	if len(stale) > 0 {
+		log.Printf("Marked %d runs from a previous process as interrupted", len(stale))
+	}
+
+	for jobID := range interrupted {
+		j, err := db.GetJob(jobID)
+		if err != nil {
+			log.Printf("Failed to load interrupted job %d: %v", jobID, err)
+			continue
+		}
+		if !j.RerunInterrupted || !j.Status {
+			continue
+		}
+
+		log.Printf("Rerunning interrupted job %s", j.Name)
+		if _, _, err := Dispatch(*j, models.TriggerRecovery); err != nil {
+			log.Printf("Failed to rerun job %s: %v", j.Name, err)
+		}
+	}
+}
+
+// interruptedDuration estimates how long an interrupted run lasted, from its
+// start until its log file was last written, or until now without a log
+func interruptedDuration(runID int64, runAt string) time.Duration {
+	start, err := time.Parse(time.RFC3339, runAt)
+	if err != nil {
+		return 0
+	}
+
+	end := time.Now()
+	if info, err := Logs.Stat(LogName(runID)); err == nil {
+		end = info.ModTime
+	}
+
+	if end.Before(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
@@ -0,0 +1,190 @@
+package jobs
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/abhilashreddysh/croncraft/internal/db"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+	"github.com/abhilashreddysh/croncraft/internal/utils"
+)
+
+// DefaultRetention holds the server-wide retention rules, used for the rules
+// a job does not set itself
+var DefaultRetention = models.Retention{KeepRuns: 10}
+
+// StartLogSweeper records the sizes of logs not measured yet, removes the
+// runs that their job's retention rules no longer keep and compresses old
+// logs, once now and then every interval until Shutdown
+func StartLogSweeper(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			MeasureLogs()
+			SweepRetention()
+			CompressOldLogs()
+			select {
+			case <-shutdownCh:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// SweepRetention removes the finished runs of every job, with their logs,
+// that the job's retention rules no longer keep
+func SweepRetention() {
+	list, err := db.GetJobsFromDB()
+	if err != nil {
+		log.Printf("Retention sweep failed: %v", err)
+		return
+	}
+
+	var removed int
+	var freed int64
+	now := time.Now()
+	for _, j := range list {
+		n, size, err := pruneRuns(j, now)
+		if err != nil {
+			log.Printf("Failed to apply retention to job %s: %v", j.Name, err)
+		}
+		removed += n
+		freed += size
+	}
+	if removed > 0 {
+		log.Printf("Retention sweep removed %d runs and %s of logs", removed, utils.FormatFileSize(freed))
+	}
+}
+
+// retainedRun is a finished run considered by the retention sweep
+type retainedRun struct {
+	id      int64
+	runAt   time.Time
+	status  string
+	logSize int64
+}
+
+// pruneRuns removes the finished runs of a job that its retention rules no
+// longer keep. It returns how many runs were removed and the size of their
+// logs.
+func pruneRuns(j models.Job, now time.Time) (int, int64, error) {
+	policy := j.Retention(DefaultRetention)
+	if policy.KeepRuns == 0 && policy.KeepDays == 0 && policy.MaxLogMB == 0 {
+		return 0, 0, nil
+	}
+
+	runs, err := finishedRuns(j.ID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	remove := runsToRemove(policy, runs, now)
+	if len(remove) == 0 {
+		return 0, 0, nil
+	}
+
+	err = utils.RetryDBOperation(func() error {
+		DbMu.Lock()
+		defer DbMu.Unlock()
+
+		tx, err := db.DB.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		for _, run := range remove {
+			if _, err := tx.Exec("DELETE FROM job_runs WHERE id = ?", run.id); err != nil {
+				return err
+			}
+		}
+		return tx.Commit()
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var freed int64
+	for _, run := range remove {
+		if err := RemoveLog(run.id); err != nil {
+			log.Printf("Failed to delete log of run %d: %v", run.id, err)
+			continue
+		}
+		freed += run.logSize
+	}
+	return len(remove), freed, nil
+}
+
+// runsToRemove returns the runs, given newest first, that policy no longer
+// keeps. Once the logs kept add up to MaxLogMB, every older run goes, but
+// the newest run is always kept.
+func runsToRemove(policy models.Retention, runs []retainedRun, now time.Time) []retainedRun {
+	var remove []retainedRun
+	var total int64
+	full := false
+	limit := int64(policy.MaxLogMB) << 20
+	for i, run := range runs {
+		keep := !full && keepRun(policy, i, run, now)
+		if keep && limit > 0 && i > 0 && total+run.logSize > limit {
+			keep, full = false, true
+		}
+		if keep {
+			total += run.logSize
+		} else {
+			remove = append(remove, run)
+		}
+	}
+	return remove
+}
+
+// keepRun reports whether the count and age rules keep a run, the index-th
+// newest finished run of its job
+func keepRun(policy models.Retention, index int, run retainedRun, now time.Time) bool {
+	if policy.KeepRuns == 0 && policy.KeepDays == 0 {
+		return true
+	}
+	if index < policy.KeepRuns {
+		return true
+	}
+	if policy.KeepDays > 0 && run.runAt.After(now.AddDate(0, 0, -policy.KeepDays)) {
+		return true
+	}
+	failed := run.status == models.StatusFailed || run.status == models.StatusTimeout ||
+		run.status == models.StatusInterrupted
+	return failed && policy.KeepFailedDays > 0 && run.runAt.After(now.AddDate(0, 0, -policy.KeepFailedDays))
+}
+
+// finishedRuns returns the finished runs of a job, newest first, with the
+// recorded size of their logs as stored
+func finishedRuns(jobID int) ([]retainedRun, error) {
+	var runs []retainedRun
+	err := utils.RetryDBOperation(func() error {
+		runs = nil
+		rows, err := db.DB.Query(`
+			SELECT id, run_at, status, COALESCE(log_stored_bytes, 0) FROM job_runs
+			WHERE job_id = ? AND status NOT IN (?, ?)
+			ORDER BY run_at DESC, id DESC`,
+			jobID, models.StatusRunning, models.StatusQueued,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var run retainedRun
+			var runAt sql.NullString
+			if err := rows.Scan(&run.id, &runAt, &run.status, &run.logSize); err != nil {
+				return err
+			}
+			run.runAt, _ = time.Parse(time.RFC3339, runAt.String)
+			runs = append(runs, run)
+		}
+		return rows.Err()
+	})
+	return runs, err
+}
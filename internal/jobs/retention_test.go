@@ -0,0 +1,104 @@
+package jobs
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/abhilashreddysh/croncraft/internal/models"
+)
+
+var retentionNow = time.Date(2026, time.March, 1, 12, 0, 0, 0, time.UTC)
+
+// daysAgo returns a finished run with the given status from days before
+// retentionNow
+func daysAgo(days float64, status string) retainedRun {
+	return retainedRun{
+		runAt:  retentionNow.Add(-time.Duration(days * float64(24*time.Hour))),
+		status: status,
+	}
+}
+
+func TestKeepRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy models.Retention
+		index  int
+		run    retainedRun
+		want   bool
+	}{
+		{"no rules", models.Retention{}, 100, daysAgo(365, models.StatusSuccess), true},
+		{"only failed days keeps every run", models.Retention{KeepFailedDays: 1}, 100, daysAgo(365, models.StatusSuccess), true},
+		{"only size limit keeps every run", models.Retention{MaxLogMB: 1}, 100, daysAgo(365, models.StatusSuccess), true},
+
+		{"within run count", models.Retention{KeepRuns: 3}, 2, daysAgo(365, models.StatusSuccess), true},
+		{"beyond run count", models.Retention{KeepRuns: 3}, 3, daysAgo(0, models.StatusSuccess), false},
+
+		{"within days", models.Retention{KeepDays: 7}, 100, daysAgo(6.9, models.StatusSuccess), true},
+		{"beyond days", models.Retention{KeepDays: 7}, 0, daysAgo(7.1, models.StatusSuccess), false},
+
+		{"count or days, kept by count", models.Retention{KeepRuns: 3, KeepDays: 7}, 1, daysAgo(30, models.StatusSuccess), true},
+		{"count or days, kept by days", models.Retention{KeepRuns: 3, KeepDays: 7}, 10, daysAgo(1, models.StatusSuccess), true},
+		{"count or days, kept by neither", models.Retention{KeepRuns: 3, KeepDays: 7}, 10, daysAgo(30, models.StatusSuccess), false},
+
+		{"failed within failed days", models.Retention{KeepRuns: 1, KeepFailedDays: 30}, 5, daysAgo(20, models.StatusFailed), true},
+		{"timeout within failed days", models.Retention{KeepRuns: 1, KeepFailedDays: 30}, 5, daysAgo(20, models.StatusTimeout), true},
+		{"interrupted within failed days", models.Retention{KeepRuns: 1, KeepFailedDays: 30}, 5, daysAgo(20, models.StatusInterrupted), true},
+		{"failed beyond failed days", models.Retention{KeepRuns: 1, KeepFailedDays: 30}, 5, daysAgo(31, models.StatusFailed), false},
+		{"success within failed days", models.Retention{KeepRuns: 1, KeepFailedDays: 30}, 5, daysAgo(20, models.StatusSuccess), false},
+		{"cancelled within failed days", models.Retention{KeepRuns: 1, KeepFailedDays: 30}, 5, daysAgo(20, models.StatusCancelled), false},
+		{"failed days shorter than days", models.Retention{KeepDays: 10, KeepFailedDays: 2}, 5, daysAgo(5, models.StatusFailed), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := keepRun(tt.policy, tt.index, tt.run, retentionNow); got != tt.want {
+				t.Errorf("keepRun = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRunsToRemove(t *testing.T) {
+	const mb = 1 << 20
+
+	// runs returns one run per day, newest first, with the given log sizes
+	runs := func(sizes ...int64) []retainedRun {
+		list := make([]retainedRun, len(sizes))
+		for i, size := range sizes {
+			list[i] = daysAgo(float64(i), models.StatusSuccess)
+			list[i].id = int64(len(sizes) - i)
+			list[i].logSize = size
+		}
+		return list
+	}
+
+	tests := []struct {
+		name   string
+		policy models.Retention
+		runs   []retainedRun
+		want   []int64 // ids of the removed runs
+	}{
+		{"no rules", models.Retention{}, runs(mb, mb, mb), nil},
+		{"run count", models.Retention{KeepRuns: 2}, runs(mb, mb, mb, mb), []int64{2, 1}},
+		{"days", models.Retention{KeepDays: 2}, runs(mb, mb, mb, mb), []int64{2, 1}},
+		{"logs fit", models.Retention{MaxLogMB: 3}, runs(mb, mb, mb), nil},
+		{"logs over the limit", models.Retention{MaxLogMB: 2}, runs(mb, mb, mb, mb), []int64{2, 1}},
+		{"older small logs go too", models.Retention{MaxLogMB: 2}, runs(mb, 2*mb, 1, 1), []int64{3, 2, 1}},
+		{"newest run kept over the limit", models.Retention{MaxLogMB: 1}, runs(5*mb, mb), []int64{1}},
+		{"size limit after run count", models.Retention{KeepRuns: 3, MaxLogMB: 1}, runs(mb/2, mb/2, mb/2, mb/2), []int64{2, 1}},
+		{"run count after size limit", models.Retention{KeepRuns: 2, MaxLogMB: 10}, runs(mb, mb, mb), []int64{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int64
+			for _, run := range runsToRemove(tt.policy, tt.runs, retentionNow) {
+				got = append(got, run.id)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("removed runs %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package jobs
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/abhilashreddysh/croncraft/internal/db"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+	"github.com/abhilashreddysh/croncraft/internal/utils"
+)
+
+// maxRetryDelay caps the exponential backoff between attempts
+const maxRetryDelay = time.Hour
+
+// pendingRetry is an attempt waiting out its backoff
+type pendingRetry struct {
+	jobID     int
+	cancelled chan struct{} // closed to cancel the attempt
+}
+
+// pendingRetries holds the queued rows of attempts waiting out their backoff,
+// so they can be cancelled before they start. Guarded by slotsMu.
+var pendingRetries = make(map[int64]pendingRetry)
+
+// runWithRetries runs the job for runID and retries it according to the
+// job's retry settings, recording every attempt as its own job_runs row
+func runWithRetries(j models.Job, runID int64, trigger string) {
+	status, exitCode := RunJob(j, runID)
+
+	parentID := runID
+	for attempt := 2; shouldRetry(j, status, exitCode, attempt); attempt++ {
+		delay := retryDelay(j, attempt)
+		log.Printf("Job %s failed with exit code %d, retrying in %s (attempt %d of %d)",
+			j.Name, exitCode, delay, attempt, j.MaxAttempts)
+
+		nextID, ok := waitForRetry(j, parentID, attempt, trigger, delay)
+		if !ok {
+			return
+		}
+		status, exitCode = RunJob(j, nextID)
+	}
+}
+
+// shouldRetry reports whether a run that ended with status and exitCode
+// should get another attempt
+func shouldRetry(j models.Job, status string, exitCode, attempt int) bool {
+	if status != models.StatusFailed || attempt > j.MaxAttempts {
+		return false
+	}
+
+	codes, err := utils.ParseExitCodes(j.RetryExitCodes)
+	if err != nil {
+		log.Printf("Invalid retry exit codes for job %s: %v", j.Name, err)
+		return false
+	}
+	return len(codes) == 0 || slices.Contains(codes, exitCode)
+}
+
+// retryDelay returns how long to wait before the given attempt
+func retryDelay(j models.Job, attempt int) time.Duration {
+	delay := time.Duration(j.RetryDelaySeconds) * time.Second
+	if j.RetryBackoff != models.BackoffExponential {
+		return delay
+	}
+
+	for i := 2; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// waitForRetry records the next attempt as queued and waits out its delay.
+// It returns false if the attempt was cancelled or could not be recorded.
+func waitForRetry(j models.Job, parentID int64, attempt int, trigger string, delay time.Duration) (int64, bool) {
+	runID, err := insertAttempt(j, parentID, attempt, trigger)
+	if err != nil {
+		log.Printf("Failed to record retry of job %s: %v", j.Name, err)
+		return 0, false
+	}
+
+	cancelled := make(chan struct{})
+	slotsMu.Lock()
+	pendingRetries[runID] = pendingRetry{jobID: j.ID, cancelled: cancelled}
+	slotsMu.Unlock()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		slotsMu.Lock()
+		delete(pendingRetries, runID)
+		slotsMu.Unlock()
+		return runID, true
+	case <-cancelled:
+		return 0, false
+	case <-shutdownCh:
+		slotsMu.Lock()
+		delete(pendingRetries, runID)
+		slotsMu.Unlock()
+		interruptRun(runID)
+		return 0, false
+	}
+}
+
+// insertAttempt creates the queued job_runs row for a retry attempt
+func insertAttempt(j models.Job, parentID int64, attempt int, trigger string) (int64, error) {
+	runAt := time.Now().Format(time.RFC3339)
+
+	var runID int64
+	err := utils.RetryDBOperation(func() error {
+		res, err := db.DB.Exec(
+			"INSERT INTO job_runs (job_id, run_at, status, trigger, output, attempt, parent_run_id, job_version) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			j.ID, runAt, models.StatusQueued, trigger, "", attempt, parentID, j.Version,
+		)
+		if err != nil {
+			return err
+		}
+		runID, err = res.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert attempt: %w", err)
+	}
+	return runID, nil
+}
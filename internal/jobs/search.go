@@ -0,0 +1,349 @@
+package jobs
+
+import (
+	"errors"
+	"html"
+	"io/fs"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/abhilashreddysh/croncraft/internal/db"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+	"github.com/abhilashreddysh/croncraft/internal/utils"
+)
+
+// SearchFullLogs indexes the whole log of each run for search rather than
+// the output preview kept in the database
+var SearchFullLogs = false
+
+// truncatedMarker ends the output preview of runs whose output did not fit
+const truncatedMarker = "... (truncated)\n"
+
+// indexBatchSize is how many lines are indexed per transaction, so that
+// indexing a long log does not hold up other writes
+const indexBatchSize = 1000
+
+// Markers around the matches in the snippets returned by the index, turned
+// into <mark> elements once the snippet has been escaped
+const (
+	matchStart = "\x01"
+	matchEnd   = "\x02"
+)
+
+// indexRequests wakes up the search indexer; a pending request covers any
+// number of finished runs
+var indexRequests = make(chan struct{}, 1)
+
+// ErrEmptySearch is returned for search queries without any words
+var ErrEmptySearch = errors.New("search query has no words")
+
+// StartSearchIndexer indexes the output of finished runs for search, once
+// now for the runs not indexed yet and then whenever a run finishes, until
+// Shutdown
+func StartSearchIndexer() {
+	go func() {
+		for {
+			IndexRunOutput()
+			select {
+			case <-shutdownCh:
+				return
+			case <-indexRequests:
+			}
+		}
+	}()
+}
+
+// requestIndexing asks the search indexer to index the runs that finished
+func requestIndexing() {
+	select {
+	case indexRequests <- struct{}{}:
+	default:
+	}
+}
+
+// IndexRunOutput adds the output of every finished run that has not been
+// indexed yet to the search index
+func IndexRunOutput() {
+	var lastID int64
+	var indexed int
+	for {
+		type unindexedRun struct {
+			id      int64
+			preview string
+		}
+		var runs []unindexedRun
+		err := utils.RetryDBOperation(func() error {
+			runs = nil
+			rows, err := db.DB.Query(`
+				SELECT id, COALESCE(output, '') FROM job_runs
+				WHERE output_indexed = 0 AND id > ? AND status NOT IN (?, ?)
+				ORDER BY id LIMIT 100`,
+				lastID, models.StatusRunning, models.StatusQueued,
+			)
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			for rows.Next() {
+				var run unindexedRun
+				if err := rows.Scan(&run.id, &run.preview); err != nil {
+					return err
+				}
+				runs = append(runs, run)
+			}
+			return rows.Err()
+		})
+		if err != nil {
+			log.Printf("Failed to list runs to index for search: %v", err)
+			return
+		}
+		if len(runs) == 0 {
+			break
+		}
+
+		for _, run := range runs {
+			lastID = run.id
+			if err := indexRun(run.id, run.preview); err != nil {
+				log.Printf("Failed to index output of run %d for search: %v", run.id, err)
+				continue
+			}
+			indexed++
+		}
+	}
+	if indexed > 1 {
+		log.Printf("Indexed the output of %d runs for search", indexed)
+	}
+}
+
+// indexedLine is a line of output to add to the search index
+type indexedLine struct {
+	n    int
+	text string
+}
+
+// indexRun adds the lines of a run's output to the search index, numbered
+// as the output viewer numbers them, and marks the run as indexed
+func indexRun(runID int64, preview string) error {
+	// Drop the lines of an earlier attempt cut short
+	err := utils.RetryDBOperation(func() error {
+		DbMu.Lock()
+		defer DbMu.Unlock()
+		_, err := db.DB.Exec("DELETE FROM output_lines WHERE run_id = ?", runID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	var batch []indexedLine
+	flush := func(done bool) error {
+		err := utils.RetryDBOperation(func() error {
+			DbMu.Lock()
+			defer DbMu.Unlock()
+
+			tx, err := db.DB.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			for _, line := range batch {
+				if _, err := tx.Exec("INSERT INTO output_lines (run_id, line, text) VALUES (?, ?, ?)",
+					runID, line.n, line.text); err != nil {
+					return err
+				}
+			}
+			if done {
+				if _, err := tx.Exec("UPDATE job_runs SET output_indexed = 1 WHERE id = ?", runID); err != nil {
+					return err
+				}
+			}
+			return tx.Commit()
+		})
+		batch = batch[:0]
+		return err
+	}
+
+	add := func(n int, text string) error {
+		if strings.TrimSpace(text) == "" {
+			return nil
+		}
+		batch = append(batch, indexedLine{n: n, text: text})
+		if len(batch) < indexBatchSize {
+			return nil
+		}
+		return flush(false)
+	}
+
+	if err := forEachIndexedLine(runID, preview, add); err != nil {
+		return err
+	}
+	return flush(true)
+}
+
+// forEachIndexedLine calls fn with every line of a run's output to index:
+// those of the full log with SearchFullLogs, otherwise those of the preview
+func forEachIndexedLine(runID int64, preview string, fn func(n int, text string) error) error {
+	if SearchFullLogs {
+		f, err := OpenLog(runID, 0)
+		if err == nil {
+			defer f.Close()
+
+			n := 0
+			scanner := NewLogScanner(f)
+			for scanner.Scan() {
+				n++
+				_, text := ParseLogLine(scanner.Text())
+				if err := fn(n, text); err != nil {
+					return err
+				}
+			}
+			return scanner.Err()
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	// The preview holds the first lines of the log, in the same order
+	preview = strings.TrimSuffix(preview, truncatedMarker)
+	n := 0
+	for line := range strings.Lines(preview) {
+		n++
+		if err := fn(n, strings.TrimSuffix(line, "\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SearchFilter selects the output lines to search. Zero fields match every
+// line.
+type SearchFilter struct {
+	Query  string // words the lines contain, as parsed by MatchQuery
+	JobID  int
+	JobIDs []int // jobs the searching account may see, nil for all
+	Status string
+	Since  time.Time
+	Until  time.Time
+}
+
+// SearchOutput returns a page of the indexed output lines matching the
+// filter, newest run first, and how many match in total. It returns
+// ErrEmptySearch for queries without words.
+func SearchOutput(f SearchFilter, limit, offset int) ([]models.OutputMatch, int, error) {
+	match := MatchQuery(f.Query)
+	if match == "" {
+		return nil, 0, ErrEmptySearch
+	}
+
+	where := []string{"output_search MATCH ?"}
+	args := []any{match}
+	if f.JobID != 0 {
+		where = append(where, "r.job_id = ?")
+		args = append(args, f.JobID)
+	}
+	if f.JobIDs != nil {
+		where = append(where, "r.job_id IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(f.JobIDs)), ", ")+")")
+		for _, id := range f.JobIDs {
+			args = append(args, id)
+		}
+	}
+	if f.Status != "" {
+		where = append(where, "r.status = ?")
+		args = append(args, f.Status)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "datetime(r.run_at) >= datetime(?)")
+		args = append(args, f.Since.UTC().Format(time.RFC3339))
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "datetime(r.run_at) < datetime(?)")
+		args = append(args, f.Until.UTC().Format(time.RFC3339))
+	}
+
+	from := `
+		FROM output_search
+		JOIN output_lines l ON l.id = output_search.rowid
+		JOIN job_runs r ON r.id = l.run_id
+		LEFT JOIN jobs j ON j.id = r.job_id
+		WHERE ` + strings.Join(where, " AND ")
+
+	var total int
+	if err := db.DB.QueryRow("SELECT COUNT(*)"+from, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.DB.Query(`
+		SELECT l.run_id, r.job_id, COALESCE(j.name, ''), r.run_at, r.status, l.line,
+		       snippet(output_search, 0, ?, ?, '…', 24)`+from+`
+		ORDER BY r.id DESC, l.line LIMIT ? OFFSET ?`,
+		append(append([]any{matchStart, matchEnd}, args...), limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	matches := []models.OutputMatch{}
+	for rows.Next() {
+		var m models.OutputMatch
+		var runAt, snippet string
+		if err := rows.Scan(&m.RunID, &m.JobID, &m.JobName, &runAt, &m.Status, &m.Line, &snippet); err != nil {
+			return nil, 0, err
+		}
+		m.RunAt, _ = time.Parse(time.RFC3339, runAt)
+		m.Highlight = highlightMatches(snippet)
+		matches = append(matches, m)
+	}
+	return matches, total, rows.Err()
+}
+
+// MatchQuery turns a search as typed into an FTS5 query that finds lines
+// containing every word. "Quoted words" must appear together as a phrase,
+// and a word ending in * matches every word starting with it. Any other
+// punctuation is taken literally, so searches cannot break the query
+// syntax. It returns "" for searches without words.
+func MatchQuery(search string) string {
+	var terms []string
+	add := func(term string) {
+		prefix := strings.HasSuffix(term, "*")
+		term = strings.TrimRight(term, "*")
+		if strings.TrimSpace(term) == "" {
+			return
+		}
+		term = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+		if prefix {
+			term += "*"
+		}
+		terms = append(terms, term)
+	}
+
+	for search != "" {
+		search = strings.TrimLeft(search, " \t\r\n")
+		if rest, ok := strings.CutPrefix(search, `"`); ok {
+			phrase, after, closed := strings.Cut(rest, `"`)
+			if closed && strings.HasPrefix(after, "*") {
+				phrase += "*"
+			}
+			add(phrase)
+			search = strings.TrimLeft(after, "*")
+			continue
+		}
+		end := strings.IndexAny(search, " \t\r\n")
+		if end < 0 {
+			end = len(search)
+		}
+		add(search[:end])
+		search = search[end:]
+	}
+	return strings.Join(terms, " ")
+}
+
+// highlightMatches escapes a snippet of an output line for HTML and wraps
+// its matches in <mark> elements
+func highlightMatches(snippet string) string {
+	s := html.EscapeString(snippet)
+	s = strings.ReplaceAll(s, matchStart, "<mark>")
+	return strings.ReplaceAll(s, matchEnd, "</mark>")
+}
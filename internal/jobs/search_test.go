@@ -0,0 +1,101 @@
+package jobs
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func TestMatchQuery(t *testing.T) {
+	tests := []struct {
+		search string
+		want   string
+		line   string // an output line the query finds, if any
+	}{
+		{"error", `"error"`, "fatal error: disk full"},
+		{"  disk \t full ", `"disk" "full"`, "full disk"},
+		{`"disk full"`, `"disk full"`, "error: disk full"},
+		{`"full disk"`, `"full disk"`, ""},
+		{"conn*", `"conn"*`, "connection refused"},
+		{"conn**", `"conn"*`, "connection refused"},
+		{`"connection ref"*`, `"connection ref"*`, "connection refused"},
+		{`say "hi`, `"say" "hi"`, "they say hi"},
+		{`x"y`, `"x""y"`, "x y"},
+		{`""`, "", ""},
+		{"*", "", ""},
+		{"   ", "", ""},
+
+		// FTS operators are searched for as words
+		{"disk AND full", `"disk" "AND" "full"`, "disk and full"},
+		{"disk OR full", `"disk" "OR" "full"`, "disk or full"},
+		{"NOT full", `"NOT" "full"`, "not full"},
+		{"NEAR(disk full)", `"NEAR(disk" "full)"`, "near disk full"},
+		{"-error", `"-error"`, "error"},
+		{"^start", `"^start"`, "start"},
+		{"text:error", `"text:error"`, "text error"},
+		{"{text}:error", `"{text}:error"`, "text error"},
+		{"(disk) +full", `"(disk)" "+full"`, "disk full"},
+	}
+
+	conn, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+	if _, err := conn.Exec("CREATE VIRTUAL TABLE search USING fts5(text)"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.search, func(t *testing.T) {
+			got := MatchQuery(tt.search)
+			if got != tt.want {
+				t.Fatalf("MatchQuery(%q) = %q, want %q", tt.search, got, tt.want)
+			}
+			if got == "" {
+				return
+			}
+
+			if _, err := conn.Exec("DELETE FROM search"); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := conn.Exec("INSERT INTO search (text) VALUES (?), ('unrelated line')", tt.line); err != nil {
+				t.Fatal(err)
+			}
+			var found int
+			if err := conn.QueryRow("SELECT COUNT(*) FROM search WHERE search MATCH ?", got).Scan(&found); err != nil {
+				t.Fatalf("query %q: %v", got, err)
+			}
+			if want := min(len(tt.line), 1); found != want {
+				t.Errorf("query %q found %d lines, want %d", got, found, want)
+			}
+		})
+	}
+}
+
+func TestHighlightMatches(t *testing.T) {
+	tests := []struct {
+		snippet string
+		want    string
+	}{
+		{"no matches", "no matches"},
+		{"fatal " + matchStart + "error" + matchEnd + ": disk full",
+			"fatal <mark>error</mark>: disk full"},
+		{matchStart + "a" + matchEnd + " and " + matchStart + "b" + matchEnd,
+			"<mark>a</mark> and <mark>b</mark>"},
+		{"<script>alert(1)</script> " + matchStart + "error" + matchEnd,
+			"&lt;script&gt;alert(1)&lt;/script&gt; <mark>error</mark>"},
+		{matchStart + "<script>" + matchEnd,
+			"<mark>&lt;script&gt;</mark>"},
+		{`say "hi" & 'bye'`, "say &#34;hi&#34; &amp; &#39;bye&#39;"},
+		{"literal <mark>tags</mark>", "literal &lt;mark&gt;tags&lt;/mark&gt;"},
+	}
+
+	for _, tt := range tests {
+		if got := highlightMatches(tt.snippet); got != tt.want {
+			t.Errorf("highlightMatches(%q) = %q, want %q", tt.snippet, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package jobs
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/abhilashreddysh/croncraft/internal/db"
+	"github.com/abhilashreddysh/croncraft/internal/models"
+	"github.com/abhilashreddysh/croncraft/internal/utils"
+)
+
+// ErrShuttingDown is returned when a run is triggered after Shutdown started
+var ErrShuttingDown = errors.New("croncraft is shutting down")
+
+var (
+	shuttingDown atomic.Bool           // set under slotsMu
+	shutdownCh   = make(chan struct{}) // closed when Shutdown starts
+	shutdownOnce sync.Once
+
+	// inFlight counts dispatched executions, including queued runs and
+	// attempts waiting for a retry. New executions are only added under
+	// slotsMu while shuttingDown is unset, or by an execution handing over
+	// to its queued run while it is still counted itself.
+	inFlight sync.WaitGroup
+)
+
+// Shutdown stops the scheduler, refuses new runs and waits up to
+// drainTimeout for in-flight runs to finish. Runs still going after that are
+// stopped and recorded as interrupted.
+func Shutdown(drainTimeout time.Duration) {
+	shutdownOnce.Do(func() {
+		slotsMu.Lock()
+		shuttingDown.Store(true)
+		slotsMu.Unlock()
+		close(shutdownCh)
+	})
+
+	if C != nil {
+		<-C.Stop().Done()
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		inFlight.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		return
+	case <-time.After(drainTimeout):
+	}
+
+	activeRunsMu.Lock()
+	runs := make([]*activeRun, 0, len(activeRuns))
+	for _, run := range activeRuns {
+		runs = append(runs, run)
+	}
+	activeRunsMu.Unlock()
+
+	log.Printf("Drain timeout reached, interrupting %d running jobs", len(runs))
+	for _, run := range runs {
+		run.stop(models.StatusInterrupted, "")
+	}
+
+	// Give stopped runs time to exit and record their status
+	select {
+	case <-drained:
+	case <-time.After(killGracePeriod + 5*time.Second):
+		log.Printf("Some runs did not finish before shutdown")
+	}
+}
+
+// interruptRun records a run that was never started because of shutdown
+func interruptRun(runID int64) {
+	_ = utils.RetryDBOperation(func() error {
+		_, err := db.DB.Exec(
+			"UPDATE job_runs SET status = ?, duration_ms = 0, log_bytes = 0, log_stored_bytes = 0 WHERE id = ?",
+			models.StatusInterrupted, runID,
+		)
+		return err
+	})
+	notifyRunFinished(runID)
+}
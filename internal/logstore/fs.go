@@ -0,0 +1,60 @@
+package logstore
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FS keeps logs as files in a local directory
+type FS struct {
+	Dir string
+}
+
+// NewFS returns a store of the logs in dir, which is created when the first
+// log is written
+func NewFS(dir string) *FS {
+	return &FS{Dir: dir}
+}
+
+func (s *FS) path(name string) string {
+	return filepath.Join(s.Dir, filepath.Base(name))
+}
+
+func (s *FS) Create(name string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(s.path(name))
+}
+
+func (s *FS) Open(name string, offset int64) (io.ReadCloser, error) {
+	f, err := os.Open(s.path(name))
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (s *FS) Delete(name string) error {
+	if err := os.Remove(s.path(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *FS) Stat(name string) (Info, error) {
+	info, err := os.Stat(s.path(name))
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
@@ -0,0 +1,404 @@
+package logstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash is the SHA-256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// DefaultS3Timeout is how long a request may go without progress when
+// S3Options sets no timeout
+const DefaultS3Timeout = 30 * time.Second
+
+// S3Options configures a store in an S3-compatible bucket
+type S3Options struct {
+	Endpoint  string // URL of the service, e.g. https://s3.eu-central-1.amazonaws.com
+	Region    string
+	Bucket    string
+	Prefix    string // put in front of log names, e.g. "croncraft/"
+	AccessKey string
+	SecretKey string
+	PathStyle bool   // name the bucket in the path instead of the host, as MinIO expects
+	SpoolDir  string // local directory logs are written to until they are complete
+
+	// Timeout is how long a request may go without sending or receiving
+	// any data before it is abandoned, DefaultS3Timeout if 0. Transfers
+	// that keep making progress may take longer.
+	Timeout time.Duration
+}
+
+// S3 keeps logs in an S3-compatible bucket. Logs being written are spooled
+// to a local file, which readers on this instance follow, and uploaded in
+// one piece once complete. Requests are signed with AWS Signature Version 4.
+type S3 struct {
+	opts     S3Options
+	endpoint *url.URL
+	client   *http.Client
+	now      func() time.Time
+}
+
+// NewS3 returns a store of the logs in an S3-compatible bucket
+func NewS3(opts S3Options) (*S3, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(opts.Endpoint, "/"))
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", opts.Endpoint)
+	}
+	switch {
+	case opts.Bucket == "":
+		return nil, errors.New("no S3 bucket given")
+	case opts.Region == "":
+		return nil, errors.New("no S3 region given")
+	case opts.AccessKey == "" || opts.SecretKey == "":
+		return nil, errors.New("no S3 access key and secret key given")
+	case opts.SpoolDir == "":
+		return nil, errors.New("no spool directory given")
+	case opts.Timeout < 0:
+		return nil, errors.New("S3 timeout must not be negative")
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultS3Timeout
+	}
+	return &S3{opts: opts, endpoint: endpoint, client: http.DefaultClient, now: time.Now}, nil
+}
+
+func (s *S3) spoolPath(name string) string {
+	return filepath.Join(s.opts.SpoolDir, filepath.Base(name))
+}
+
+func (s *S3) Create(name string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(s.opts.SpoolDir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(s.spoolPath(name))
+	if err != nil {
+		return nil, err
+	}
+	return &spoolWriter{File: f, store: s, name: name}, nil
+}
+
+// spoolWriter writes a log to its spool file and uploads it when closed
+type spoolWriter struct {
+	*os.File
+	store *S3
+	name  string
+}
+
+func (w *spoolWriter) Close() error {
+	if err := w.File.Close(); err != nil {
+		return err
+	}
+	// A log that failed to upload stays in the spool directory, where this
+	// instance can still read it
+	if err := w.store.upload(w.name, w.File.Name()); err != nil {
+		return err
+	}
+	return os.Remove(w.File.Name())
+}
+
+// upload puts the file at path into the bucket as the log name
+func (s *S3) upload(name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	resp, err := s.do(http.MethodPut, name, f, size, hex.EncodeToString(h.Sum(nil)), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s.responseError(http.MethodPut, name, resp)
+	}
+	return nil
+}
+
+func (s *S3) Open(name string, offset int64) (io.ReadCloser, error) {
+	if f, err := os.Open(s.spoolPath(name)); err == nil {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return f, nil
+	}
+
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := s.do(http.MethodGet, name, nil, 0, emptyPayloadHash, header)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		// The whole log, from a service that ignored the range
+		if offset > 0 {
+			if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil && !errors.Is(err, io.EOF) {
+				resp.Body.Close()
+				return nil, err
+			}
+		}
+		return resp.Body, nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Nothing past the end of the log
+		resp.Body.Close()
+		return io.NopCloser(strings.NewReader("")), nil
+	default:
+		defer resp.Body.Close()
+		return nil, s.responseError("open", name, resp)
+	}
+}
+
+func (s *S3) Delete(name string) error {
+	if err := os.Remove(s.spoolPath(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	resp, err := s.do(http.MethodDelete, name, nil, 0, emptyPayloadHash, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	}
+	return s.responseError(http.MethodDelete, name, resp)
+}
+
+func (s *S3) Stat(name string) (Info, error) {
+	if info, err := os.Stat(s.spoolPath(name)); err == nil {
+		return Info{Size: info.Size(), ModTime: info.ModTime()}, nil
+	}
+
+	resp, err := s.do(http.MethodHead, name, nil, 0, emptyPayloadHash, nil)
+	if err != nil {
+		return Info{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Info{}, s.responseError("stat", name, resp)
+	}
+
+	size, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return Info{}, fmt.Errorf("stat %s: invalid Content-Length", name)
+	}
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return Info{Size: size, ModTime: modTime}, nil
+}
+
+// do sends a signed request for the log name. The request is abandoned once
+// it has gone the store's timeout without sending or receiving data, so a
+// stalled service cannot hold up runs or page views.
+func (s *S3) do(method, name string, body io.Reader, size int64, payloadHash string, header http.Header) (*http.Response, error) {
+	u := *s.endpoint
+	key := s.opts.Prefix + name
+	if s.opts.PathStyle {
+		u.Path = u.Path + "/" + s.opts.Bucket + "/" + key
+		u.RawPath = uriEncode(u.Path, false)
+	} else {
+		u.Host = s.opts.Bucket + "." + u.Host
+		u.Path = u.Path + "/" + key
+		u.RawPath = uriEncode(u.Path, false)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stall := time.AfterFunc(s.opts.Timeout, cancel)
+	progress := func() { stall.Reset(s.opts.Timeout) }
+	stop := func() {
+		stall.Stop()
+		cancel()
+	}
+	if body != nil {
+		body = &progressReader{Reader: body, progress: progress}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		stop()
+		return nil, err
+	}
+	req.ContentLength = size
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	s.sign(req, payloadHash, s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		stalled := ctx.Err() != nil
+		stop()
+		if stalled {
+			return nil, fmt.Errorf("s3 %s %s: no progress for %s", method, name, s.opts.Timeout)
+		}
+		return nil, err
+	}
+	resp.Body = &progressBody{ReadCloser: resp.Body, progress: progress, stop: stop}
+	return resp, nil
+}
+
+// progressReader reports every read of a request body as progress
+type progressReader struct {
+	io.Reader
+	progress func()
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.progress()
+	}
+	return n, err
+}
+
+// progressBody reports every read of a response body as progress, and
+// ends the request's timeout when closed
+type progressBody struct {
+	io.ReadCloser
+	progress func()
+	stop     func()
+}
+
+func (b *progressBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.progress()
+	}
+	return n, err
+}
+
+func (b *progressBody) Close() error {
+	b.stop()
+	return b.ReadCloser.Close()
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to the request
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := amzDate[:8] + "/" + s.opts.Region + "/s3/aws4_request"
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Sign the host, the range and every x-amz- header
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if k == "range" || strings.HasPrefix(k, "x-amz-") {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	slices.Sort(names)
+
+	var canonicalHeaders strings.Builder
+	for _, k := range names {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + s.opts.SecretKey)
+	for _, part := range []string{amzDate[:8], s.opts.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.opts.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery returns the query parameters sorted and encoded for signing
+func canonicalQuery(query url.Values) string {
+	var params []string
+	for k, values := range query {
+		for _, v := range values {
+			params = append(params, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	slices.Sort(params)
+	return strings.Join(params, "&")
+}
+
+// uriEncode percent-encodes every byte but the unreserved characters, and
+// slashes unless encodeSlash is set, as Signature Version 4 requires
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// errorCode finds the code in the XML body of an S3 error response
+var errorCode = regexp.MustCompile(`<Code>([^<]*)</Code>`)
+
+// responseError describes a failed request. Missing logs match
+// fs.ErrNotExist.
+func (s *S3) responseError(op, name string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if m := errorCode.FindSubmatch(body); m != nil {
+		return fmt.Errorf("s3 %s %s: %s (%s)", op, name, resp.Status, m[1])
+	}
+	return fmt.Errorf("s3 %s %s: %s", op, name, resp.Status)
+}
@@ -0,0 +1,34 @@
+// Package logstore keeps the full logs of runs, on the local filesystem or
+// in an S3-compatible object store.
+package logstore
+
+import (
+	"io"
+	"time"
+)
+
+// Store keeps logs as named objects. Names are plain file names such as
+// "42.log". Missing objects are reported with errors matching
+// fs.ErrNotExist.
+type Store interface {
+	// Create returns a writer for a new log, replacing any log of the same
+	// name. What is written can be read while the writer is open; the log
+	// is complete once the writer is closed.
+	Create(name string) (io.WriteCloser, error)
+
+	// Open returns a reader of a log from offset bytes on. Reading a log
+	// that is still being written returns what has been written so far.
+	Open(name string, offset int64) (io.ReadCloser, error)
+
+	// Delete removes a log. Deleting a missing log is not an error.
+	Delete(name string) error
+
+	// Stat returns the size of a log and when it was last written
+	Stat(name string) (Info, error)
+}
+
+// Info describes a stored log
+type Info struct {
+	Size    int64
+	ModTime time.Time
+}
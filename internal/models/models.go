@@ -3,20 +3,312 @@ package models
 import "time"
 
 type Job struct {
-	ID       int
-	Name     string
-	Schedule string
-	Command  string
-	Status  bool
-    LastRun  string
-    CreatedAt string
-    UpdatedAt string
+	ID             int    `json:"id" openapi:"readonly"`
+	Name           string `json:"name"`
+	Schedule       string `json:"schedule"`
+	Command        string `json:"command"`
+	Status         bool   `json:"enabled"`
+	TimeZone       string `json:"timezone"`        // IANA zone of the schedule; empty uses the server's zone
+	TimeoutSeconds int    `json:"timeout_seconds"` // 0 means no timeout
+	Concurrency    string `json:"concurrency"`
+
+	// Retries of failed runs
+	MaxAttempts       int    `json:"max_attempts"`        // total attempts, 1 means no retries
+	RetryBackoff      string `json:"retry_backoff"`       // fixed or exponential
+	RetryDelaySeconds int    `json:"retry_delay_seconds"` // delay before the first retry
+	RetryExitCodes    string `json:"retry_exit_codes"`    // comma separated; empty retries any failure
+
+	RerunInterrupted bool `json:"rerun_interrupted"` // rerun when a run was cut short by a restart
+
+	// Runs missed while CronCraft was down
+	MisfirePolicy string `json:"misfire_policy"` // ignore, once or all
+	MisfireMax    int    `json:"misfire_max"`    // most missed runs to catch up with the all policy
+
+	// Retention of finished runs and their logs; 0 uses the server-wide setting
+	KeepRuns       int `json:"keep_runs"`        // newest runs kept
+	KeepDays       int `json:"keep_days"`        // runs kept for this many days
+	KeepFailedDays int `json:"keep_failed_days"` // failed runs kept for this many days
+	MaxLogMB       int `json:"max_log_mb"`       // total size of the job's run logs
+
+	Version int `json:"version" openapi:"readonly"` // bumped whenever the definition changes
+
+	LastRun   string      `json:"-"`
+	LastRunAt time.Time   `json:"last_run_at,omitzero" openapi:"readonly"` // in the job's time zone, zero if it never ran
+	NextRuns  []time.Time `json:"next_runs,omitempty" openapi:"readonly"`  // upcoming runs in the job's time zone, none if disabled
+	CreatedAt string      `json:"-"`
+	UpdatedAt string      `json:"-"`
 }
 
 type Run struct {
-    ID         int
-    RunAt      time.Time
-    Status     string
-    Duration   string
-    OutputSize string
-}
\ No newline at end of file
+	ID             int       `json:"id"`
+	JobID          int       `json:"job_id"`
+	RunAt          time.Time `json:"run_at"`
+	Status         string    `json:"status"`
+	Duration       string    `json:"-"`
+	DurationMs     *int64    `json:"duration_ms"` // nil while the run has not finished
+	OutputSize     string    `json:"-"`
+	OutputBytes    int64     `json:"output_bytes"`     // size of the output preview stored in the DB
+	LogBytes       int64     `json:"log_bytes"`        // size of the full log, uncompressed
+	LogStoredBytes int64     `json:"log_stored_bytes"` // size of the full log on disk
+	LogCompressed  bool      `json:"log_compressed"`   // whether the full log has been compressed
+	CancelledBy    string    `json:"cancelled_by,omitempty"`
+	ExitCode       *int      `json:"exit_code"`        // nil if the command did not exit normally
+	Signal         string    `json:"signal,omitempty"` // signal that terminated the command, if any
+	Trigger        string    `json:"trigger"`
+	JobVersion     int       `json:"job_version,omitempty"` // version of the job definition the run used, 0 if unknown
+	Attempt        int       `json:"attempt"`
+	ParentID       int       `json:"parent_run_id,omitempty"` // first attempt of a retried run, 0 for the first attempt itself
+	Retries        []Run     `json:"-"`                       // later attempts, only set on the first attempt
+	CanCancel      bool      `json:"-"`                       // whether the viewing account may cancel the run
+}
+
+// Run statuses stored in job_runs.status
+const (
+	StatusRunning     = "running"
+	StatusSuccess     = "success"
+	StatusFailed      = "failed"
+	StatusTimeout     = "timeout"
+	StatusCancelled   = "cancelled"
+	StatusSkipped     = "skipped"
+	StatusQueued      = "queued"
+	StatusInterrupted = "interrupted"
+)
+
+// Overlap policies deciding what happens when a job is triggered while a
+// previous run is still in progress
+const (
+	ConcurrencyAllow   = "allow"   // start another run alongside it
+	ConcurrencySkip    = "skip"    // record a skipped run
+	ConcurrencyQueue   = "queue"   // run once the current run finishes, at most one waiting
+	ConcurrencyReplace = "replace" // cancel the running one and start over
+)
+
+// Retry backoff strategies
+const (
+	BackoffFixed       = "fixed"
+	BackoffExponential = "exponential"
+)
+
+// What started a run
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+	TriggerCatchUp  = "catch-up"
+	TriggerRecovery = "recovery" // rerun of an interrupted run
+)
+
+// Policies for runs missed while CronCraft was down
+const (
+	MisfireIgnore = "ignore"
+	MisfireOnce   = "once"
+	MisfireAll    = "all"
+)
+
+// Retention decides which finished runs of a job, and their logs, are kept.
+// A run is kept while the KeepRuns, KeepDays or KeepFailedDays rule keeps
+// it; with neither KeepRuns nor KeepDays set, every run is. The oldest runs
+// are then removed until the job's logs fit in MaxLogMB. A rule of 0 is off.
+type Retention struct {
+	KeepRuns       int // newest runs kept
+	KeepDays       int // runs kept for this many days
+	KeepFailedDays int // failed, timed out and interrupted runs kept for this many days
+	MaxLogMB       int // total size of the job's run logs
+}
+
+// Retention returns the job's retention rules, taking the rules it does
+// not set from def
+func (j *Job) Retention(def Retention) Retention {
+	r := def
+	if j.KeepRuns > 0 {
+		r.KeepRuns = j.KeepRuns
+	}
+	if j.KeepDays > 0 {
+		r.KeepDays = j.KeepDays
+	}
+	if j.KeepFailedDays > 0 {
+		r.KeepFailedDays = j.KeepFailedDays
+	}
+	if j.MaxLogMB > 0 {
+		r.MaxLogMB = j.MaxLogMB
+	}
+	return r
+}
+
+// Account roles, from least to most access. Each role has the access of
+// the roles before it.
+const (
+	RoleViewer   = "viewer"   // read the dashboard and logs
+	RoleOperator = "operator" // trigger and cancel runs
+	RoleAdmin    = "admin"    // create, edit and delete jobs, manage accounts
+)
+
+// Roles lists the account roles, from least to most access
+var Roles = []string{RoleViewer, RoleOperator, RoleAdmin}
+
+// API token scopes, each allowing one kind of action
+const (
+	ScopeTrigger = "trigger" // trigger and cancel runs
+	ScopeRead    = "read"    // read jobs, runs and logs
+	ScopeAdmin   = "admin"   // everything an admin can do
+)
+
+// Scopes lists the API token scopes
+var Scopes = []string{ScopeTrigger, ScopeRead, ScopeAdmin}
+
+// scopeRoles maps each token scope to the role whose actions it allows
+var scopeRoles = map[string]string{
+	ScopeTrigger: RoleOperator,
+	ScopeRead:    RoleViewer,
+	ScopeAdmin:   RoleAdmin,
+}
+
+// ScopeRole returns the role whose actions a token scope allows, or "" for
+// an unknown scope
+func ScopeRole(scope string) string {
+	return scopeRoles[scope]
+}
+
+// User is an account that can sign in to CronCraft, or the stand-in for an
+// API token on requests made with one
+type User struct {
+	ID       int
+	Username string
+	Role     string
+	// JobScoped limits a non-admin account to the jobs in Grants, which maps
+	// each job ID to the role the account has on it. Other accounts see
+	// every job, with their own role.
+	JobScoped bool
+	Grants    map[int]string
+	// Scope is set for requests made with an API token and limits them to
+	// the actions of that scope
+	Scope     string
+	CreatedAt string
+}
+
+// JobRole returns the user's role on a job, or "" when the job is hidden
+// from them
+func (u *User) JobRole(jobID int) string {
+	if u == nil {
+		return ""
+	}
+	if u.Role == RoleAdmin || !u.JobScoped {
+		return u.Role
+	}
+	return u.Grants[jobID]
+}
+
+// GrantedJobs returns the IDs of the jobs visible to the user, or nil when
+// every job is
+func (u *User) GrantedJobs() []int {
+	if u == nil || u.Role == RoleAdmin || !u.JobScoped {
+		return nil
+	}
+	ids := make([]int, 0, len(u.Grants))
+	for id := range u.Grants {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// CanSee reports whether the job is visible to the user
+func (u *User) CanSee(jobID int) bool {
+	return u.JobRole(jobID) != ""
+}
+
+// Can reports whether the user has at least role on the job. A jobID of 0
+// checks the account's own role, for actions that concern no single job.
+func (u *User) Can(role string, jobID int) bool {
+	if u == nil {
+		return false
+	}
+	// Trigger and read tokens allow only the actions of their own level
+	if u.Scope != "" && u.Scope != ScopeAdmin && ScopeRole(u.Scope) != role {
+		return false
+	}
+	have := u.Role
+	if jobID != 0 {
+		have = u.JobRole(jobID)
+	}
+	return roleRank(have) >= roleRank(role) && have != ""
+}
+
+// ValidRole reports whether role is one of the account roles
+func ValidRole(role string) bool {
+	return roleRank(role) > 0
+}
+
+func roleRank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// APIToken is a named credential for automation, sent as a Bearer token
+type APIToken struct {
+	ID        int
+	Name      string
+	Scope     string
+	JobScoped bool  // whether the token is limited to JobIDs
+	JobIDs    []int // jobs the token is limited to
+	CreatedBy string
+	CreatedAt time.Time
+	ExpiresAt time.Time // zero for tokens that do not expire
+	LastUsed  time.Time // zero until the token is first used
+}
+
+// Audited actions
+const (
+	AuditCreate    = "create"
+	AuditUpdate    = "update"
+	AuditDelete    = "delete"
+	AuditEnable    = "enable"
+	AuditDisable   = "disable"
+	AuditManualRun = "manual-run"
+	AuditCancel    = "cancel"
+)
+
+// AuditActions lists the audited actions
+var AuditActions = []string{AuditCreate, AuditUpdate, AuditDelete, AuditEnable, AuditDisable,
+	AuditManualRun, AuditCancel}
+
+// AuditEvent records who changed a job or acted on it, and how
+type AuditEvent struct {
+	ID      int64                  `json:"id"`
+	Time    time.Time              `json:"time"`
+	Actor   string                 `json:"actor"`
+	IP      string                 `json:"ip"`
+	Action  string                 `json:"action"`
+	JobID   int                    `json:"job_id"`
+	JobName string                 `json:"job_name"`
+	RunID   int64                  `json:"run_id,omitempty"` // run started or cancelled, if any
+	Changes map[string]FieldChange `json:"changes,omitempty"`
+}
+
+// FieldChange is the value of one job field before and after a change.
+// Before is null for created jobs and After is null for deleted ones.
+type FieldChange struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// JobVersion is an earlier definition of a job, kept when a change
+// replaced it
+type JobVersion struct {
+	Version    int
+	Job        Job       // the definition as it was, without the enabled flag
+	ReplacedAt time.Time // when the next version replaced it
+}
+
+// OutputMatch is a line of a run's output that matched a search
+type OutputMatch struct {
+	RunID     int       `json:"run_id"`
+	JobID     int       `json:"job_id"`
+	JobName   string    `json:"job_name"`
+	RunAt     time.Time `json:"run_at"`
+	Status    string    `json:"status"`
+	Line      int       `json:"line"`      // number of the line in the output viewer, from 1
+	Highlight string    `json:"highlight"` // HTML of the matching part of the line, matches in <mark>
+}
@@ -0,0 +1,131 @@
+package models
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestUserCan(t *testing.T) {
+	const granted, other = 1, 2
+
+	tokenUser := func(scope string) *User {
+		return &User{Username: "token:test", Role: ScopeRole(scope), Scope: scope}
+	}
+	jobToken := func(scope string) *User {
+		// As built by auth for tokens limited to jobs
+		return &User{
+			Username:  "token:test",
+			Role:      RoleViewer,
+			Scope:     scope,
+			JobScoped: true,
+			Grants:    map[int]string{granted: ScopeRole(scope)},
+		}
+	}
+
+	tests := []struct {
+		name string
+		user *User
+		// Whether the user has each role, in the order of Roles, on the
+		// account (job 0), the granted job and another job
+		account, grantedJob, otherJob [3]bool
+	}{
+		{"nobody", nil, [3]bool{}, [3]bool{}, [3]bool{}},
+		{"viewer", &User{Role: RoleViewer},
+			[3]bool{true, false, false}, [3]bool{true, false, false}, [3]bool{true, false, false}},
+		{"operator", &User{Role: RoleOperator},
+			[3]bool{true, true, false}, [3]bool{true, true, false}, [3]bool{true, true, false}},
+		{"admin", &User{Role: RoleAdmin},
+			[3]bool{true, true, true}, [3]bool{true, true, true}, [3]bool{true, true, true}},
+		{"unknown role", &User{Role: "root"}, [3]bool{}, [3]bool{}, [3]bool{}},
+
+		{"operator granted viewer", &User{Role: RoleOperator, JobScoped: true, Grants: map[int]string{granted: RoleViewer}},
+			[3]bool{true, true, false}, [3]bool{true, false, false}, [3]bool{}},
+		{"operator granted operator", &User{Role: RoleOperator, JobScoped: true, Grants: map[int]string{granted: RoleOperator}},
+			[3]bool{true, true, false}, [3]bool{true, true, false}, [3]bool{}},
+		{"viewer granted operator", &User{Role: RoleViewer, JobScoped: true, Grants: map[int]string{granted: RoleOperator}},
+			[3]bool{true, false, false}, [3]bool{true, true, false}, [3]bool{}},
+		{"job scoped without grants", &User{Role: RoleOperator, JobScoped: true},
+			[3]bool{true, true, false}, [3]bool{}, [3]bool{}},
+		{"grants without job scope", &User{Role: RoleOperator, Grants: map[int]string{granted: RoleViewer}},
+			[3]bool{true, true, false}, [3]bool{true, true, false}, [3]bool{true, true, false}},
+		{"admin ignores grants", &User{Role: RoleAdmin, JobScoped: true, Grants: map[int]string{granted: RoleViewer}},
+			[3]bool{true, true, true}, [3]bool{true, true, true}, [3]bool{true, true, true}},
+
+		{"read token", tokenUser(ScopeRead),
+			[3]bool{true, false, false}, [3]bool{true, false, false}, [3]bool{true, false, false}},
+		{"trigger token", tokenUser(ScopeTrigger),
+			[3]bool{false, true, false}, [3]bool{false, true, false}, [3]bool{false, true, false}},
+		{"admin token", tokenUser(ScopeAdmin),
+			[3]bool{true, true, true}, [3]bool{true, true, true}, [3]bool{true, true, true}},
+		{"unknown scope", tokenUser("write"), [3]bool{}, [3]bool{}, [3]bool{}},
+		{"job read token", jobToken(ScopeRead),
+			[3]bool{true, false, false}, [3]bool{true, false, false}, [3]bool{}},
+		{"job trigger token", jobToken(ScopeTrigger),
+			[3]bool{false, false, false}, [3]bool{false, true, false}, [3]bool{}},
+		{"job admin token", jobToken(ScopeAdmin),
+			[3]bool{true, false, false}, [3]bool{true, true, true}, [3]bool{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, c := range []struct {
+				jobID int
+				want  [3]bool
+			}{{0, tt.account}, {granted, tt.grantedJob}, {other, tt.otherJob}} {
+				for i, role := range Roles {
+					if got := tt.user.Can(role, c.jobID); got != c.want[i] {
+						t.Errorf("Can(%s, %d) = %v, want %v", role, c.jobID, got, c.want[i])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestUserJobRole(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    *User
+		jobRole string // role on job 1
+		other   string // role on job 2
+		granted []int  // GrantedJobs, nil for every job
+	}{
+		{"nobody", nil, "", "", nil},
+		{"viewer", &User{Role: RoleViewer}, RoleViewer, RoleViewer, nil},
+		{"operator with grants", &User{Role: RoleOperator, JobScoped: true, Grants: map[int]string{1: RoleViewer}},
+			RoleViewer, "", []int{1}},
+		{"operator without grants", &User{Role: RoleOperator, JobScoped: true}, "", "", []int{}},
+		{"admin", &User{Role: RoleAdmin, JobScoped: true, Grants: map[int]string{1: RoleViewer}},
+			RoleAdmin, RoleAdmin, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.user.JobRole(1); got != tt.jobRole {
+				t.Errorf("JobRole(1) = %q, want %q", got, tt.jobRole)
+			}
+			if got := tt.user.JobRole(2); got != tt.other {
+				t.Errorf("JobRole(2) = %q, want %q", got, tt.other)
+			}
+			if got := tt.user.CanSee(2); got != (tt.other != "") {
+				t.Errorf("CanSee(2) = %v", got)
+			}
+
+			got := tt.user.GrantedJobs()
+			if (got == nil) != (tt.granted == nil) || !slices.Equal(got, tt.granted) {
+				t.Errorf("GrantedJobs() = %#v, want %#v", got, tt.granted)
+			}
+		})
+	}
+}
+
+func TestScopeRole(t *testing.T) {
+	for _, scope := range Scopes {
+		if !ValidRole(ScopeRole(scope)) {
+			t.Errorf("scope %s maps to no role", scope)
+		}
+	}
+	if got := ScopeRole("write"); got != "" {
+		t.Errorf("ScopeRole(write) = %q, want none", got)
+	}
+}
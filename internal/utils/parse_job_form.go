@@ -2,39 +2,171 @@ package utils
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/abhilashreddysh/croncraft/internal/models"
-	"github.com/robfig/cron/v3"
 )
 
+// NewJob returns a job with the default settings used for fields that are
+// left out when a job is created
+func NewJob() *models.Job {
+	return &models.Job{
+		Concurrency:       models.ConcurrencyAllow,
+		MaxAttempts:       1,
+		RetryBackoff:      models.BackoffFixed,
+		RetryDelaySeconds: 30,
+		MisfirePolicy:     models.MisfireIgnore,
+		MisfireMax:        10,
+	}
+}
+
 // ParseJobForm reads form values from the request and returns a Job struct
 func ParseJobForm(r *http.Request) (*models.Job, error) {
 	if err := r.ParseForm(); err != nil {
 		return nil, errors.New("invalid form data")
 	}
 
-	name := strings.TrimSpace(r.FormValue("name"))
-	schedule := strings.TrimSpace(r.FormValue("schedule"))
-	command := strings.TrimSpace(r.FormValue("command"))
-	status := r.FormValue("enabled") == "on"
+	job := NewJob()
+	job.Name = r.FormValue("name")
+	job.Schedule = r.FormValue("schedule")
+	job.Command = r.FormValue("command")
+	job.TimeZone = r.FormValue("timezone")
+	job.Status = r.FormValue("enabled") == "on"
+	job.RerunInterrupted = r.FormValue("rerun_interrupted") == "on"
+	job.RetryBackoff = r.FormValue("retry_backoff")
+	job.RetryExitCodes = r.FormValue("retry_exit_codes")
+	job.Concurrency = r.FormValue("concurrency")
+	job.MisfirePolicy = r.FormValue("misfire_policy")
+
+	ints := []struct {
+		field string
+		dest  *int
+		err   error
+	}{
+		{"timeout", &job.TimeoutSeconds, errTimeout},
+		{"max_attempts", &job.MaxAttempts, errMaxAttempts},
+		{"retry_delay", &job.RetryDelaySeconds, errRetryDelay},
+		{"misfire_max", &job.MisfireMax, errMisfireMax},
+		{"keep_runs", &job.KeepRuns, errRetention},
+		{"keep_days", &job.KeepDays, errRetention},
+		{"keep_failed_days", &job.KeepFailedDays, errRetention},
+		{"max_log_mb", &job.MaxLogMB, errRetention},
+	}
+	for _, f := range ints {
+		n, err := formInt(r, f.field, *f.dest)
+		if err != nil {
+			return nil, f.err
+		}
+		*f.dest = n
+	}
+
+	if err := ValidateJob(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+var (
+	errTimeout     = errors.New("timeout must be a whole number of seconds, 0 for none")
+	errMaxAttempts = errors.New("max attempts must be a whole number, at least 1")
+	errRetryDelay  = errors.New("retry delay must be a whole number of seconds")
+	errMisfireMax  = errors.New("missed runs limit must be a whole number, at least 1")
+	errRetention   = errors.New("retention limits must be whole numbers, 0 for the server default")
+)
+
+// ValidateJob trims and checks a job definition, filling in the default of
+// empty policy fields. Both the form handlers and the JSON API use it.
+func ValidateJob(job *models.Job) error {
+	job.Name = strings.TrimSpace(job.Name)
+	job.Schedule = strings.TrimSpace(job.Schedule)
+	job.Command = strings.TrimSpace(job.Command)
+	job.TimeZone = strings.TrimSpace(job.TimeZone)
+	job.RetryExitCodes = strings.TrimSpace(job.RetryExitCodes)
+
+	if job.Name == "" || job.Schedule == "" || job.Command == "" {
+		return errors.New("all fields are required")
+	}
+
+	switch {
+	case job.TimeoutSeconds < 0:
+		return errTimeout
+	case job.MaxAttempts < 1:
+		return errMaxAttempts
+	case job.RetryDelaySeconds < 0:
+		return errRetryDelay
+	case job.MisfireMax < 1:
+		return errMisfireMax
+	case job.KeepRuns < 0, job.KeepDays < 0, job.KeepFailedDays < 0, job.MaxLogMB < 0:
+		return errRetention
+	}
+
+	switch job.RetryBackoff {
+	case "":
+		job.RetryBackoff = models.BackoffFixed
+	case models.BackoffFixed, models.BackoffExponential:
+	default:
+		return errors.New("invalid retry backoff: " + job.RetryBackoff)
+	}
+
+	if _, err := ParseExitCodes(job.RetryExitCodes); err != nil {
+		return err
+	}
+
+	switch job.Concurrency {
+	case "":
+		job.Concurrency = models.ConcurrencyAllow
+	case models.ConcurrencyAllow, models.ConcurrencySkip, models.ConcurrencyQueue, models.ConcurrencyReplace:
+	default:
+		return errors.New("invalid overlap policy: " + job.Concurrency)
+	}
 
-	if name == "" || schedule == "" || command == "" {
-		return nil, errors.New("all fields are required")
+	switch job.MisfirePolicy {
+	case "":
+		job.MisfirePolicy = models.MisfireIgnore
+	case models.MisfireIgnore, models.MisfireOnce, models.MisfireAll:
+	default:
+		return errors.New("invalid missed run policy: " + job.MisfirePolicy)
 	}
 
 	// Validate cron expression
-	if _, err := cron.ParseStandard(schedule); err != nil {
-		return nil, errors.New("invalid cron expression: " + err.Error())
+	if _, err := ValidateSchedule(job.Schedule, job.TimeZone); err != nil {
+		return err
 	}
+	return nil
+}
 
-	job := &models.Job{
-		Name:     name,
-		Schedule: schedule,
-		Command:  command,
-		Status:   status,
+// formInt reads an optional whole-number form field, returning def when the
+// field is empty and an error when it is not a number
+func formInt(r *http.Request, field string, def int) (int, error) {
+	v := strings.TrimSpace(r.FormValue(field))
+	if v == "" {
+		return def, nil
 	}
 
-	return job, nil
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q", field, v)
+	}
+	return n, nil
+}
+
+// ParseExitCodes parses a comma separated list of process exit codes
+func ParseExitCodes(s string) ([]int, error) {
+	var codes []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		code, err := strconv.Atoi(part)
+		if err != nil || code < 1 || code > 255 {
+			return nil, fmt.Errorf("invalid exit code %q: must be between 1 and 255", part)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
 }
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// ServerLocation is the time zone of schedules without one of their own, the
+// system's unless configured otherwise
+var ServerLocation = time.Local
+
+// CronSpec returns the cron spec for a schedule in the given time zone. An
+// empty zone leaves the schedule in the server's zone.
+func CronSpec(schedule, tz string) string {
+	if tz == "" {
+		return schedule
+	}
+	return "CRON_TZ=" + tz + " " + schedule
+}
+
+// ParseSchedule parses a standard cron schedule in the given time zone, or
+// in ServerLocation when it is empty
+func ParseSchedule(schedule, tz string) (cron.Schedule, error) {
+	s, err := cron.ParseStandard(CronSpec(schedule, tz))
+	if err != nil {
+		return nil, err
+	}
+	if spec, ok := s.(*cron.SpecSchedule); ok && tz == "" && !hasZonePrefix(schedule) {
+		spec.Location = ServerLocation
+	}
+	return s, nil
+}
+
+// ValidateSchedule checks a schedule and its time zone as entered by a user
+// and returns the parsed schedule
+func ValidateSchedule(schedule, tz string) (cron.Schedule, error) {
+	if tz != "" {
+		if _, err := time.LoadLocation(tz); err != nil {
+			return nil, errors.New("unknown time zone: " + tz)
+		}
+	}
+
+	if hasZonePrefix(schedule) {
+		return nil, errors.New("set the time zone with the time zone field, not in the schedule")
+	}
+
+	s, err := ParseSchedule(schedule, tz)
+	if err != nil {
+		return nil, errors.New("invalid cron expression: " + err.Error())
+	}
+	return s, nil
+}
+
+// NextRuns returns the next n fire times of a schedule after from
+func NextRuns(s cron.Schedule, from time.Time, n int) []time.Time {
+	var times []time.Time
+	for t := s.Next(from); !t.IsZero() && len(times) < n; t = s.Next(t) {
+		times = append(times, t)
+	}
+	return times
+}
+
+// LoadLocation returns the location for a job's time zone, falling back to
+// the server's zone when it is empty or unknown
+func LoadLocation(tz string) *time.Location {
+	if tz == "" {
+		return ServerLocation
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return ServerLocation
+	}
+	return loc
+}
+
+// hasZonePrefix reports whether a schedule sets its own time zone
+func hasZonePrefix(schedule string) bool {
+	return strings.HasPrefix(schedule, "CRON_TZ=") || strings.HasPrefix(schedule, "TZ=")
+}
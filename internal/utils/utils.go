@@ -40,7 +40,9 @@ func isDBLockedError(err error) bool {
 		err.Error() == "database is locked (5) (SQLITE_BUSY)")
 }
 
-func CleanupEmptyLogs(logDir string) {
+// CleanupEmptyLogs removes empty log files, except those inUse reports as
+// still being written
+func CleanupEmptyLogs(logDir string, inUse func(name string) bool) {
     files, err := os.ReadDir(logDir)
     if err != nil {
         log.Printf("Failed to read log dir for cleanup: %v", err)
@@ -54,7 +56,7 @@ func CleanupEmptyLogs(logDir string) {
             if err != nil {
                 continue
             }
-            if info.Size() == 0 {
+            if info.Size() == 0 && !inUse(f.Name()) {
                 _ = os.Remove(path)
                 log.Printf("Removed empty log file: %s", path)
             }